@@ -0,0 +1,105 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Usage describes the arguments Command accepts
+const Usage = `migrate up           apply every pending migration
+migrate down [steps] revert the last steps migrations (default 1)
+migrate status       list migrations and whether they are applied`
+
+// Command runs the migrate subcommand in args ("up", "down [steps]" or
+// "status") and reports what it did to w
+func Command(ctx context.Context, m *Migrator, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing subcommand\n%s", Usage)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			fmt.Fprintf(w, "applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(w, "no pending migrations")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("steps must be a positive integer: %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := m.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Fprintf(w, "reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Fprintln(w, "no applied migrations")
+		}
+		return err
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+		for _, status := range statuses {
+			state := "pending"
+			switch {
+			case status.RecordedName != "":
+				state = "applied " + status.AppliedAt.Format(time.RFC3339) + " as " + status.RecordedName + " (name mismatch)"
+			case status.Missing:
+				state = "applied " + status.AppliedAt.Format(time.RFC3339) + " (file missing)"
+			case status.Applied:
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, state)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown subcommand %q\n%s", args[0], Usage)
+}
+
+// Run is the migration step of a service's main. When args start with
+// "migrate" it runs the subcommand that follows, reporting to w, and
+// returns done so that main exits instead of serving. Otherwise auto
+// applies the pending migrations, logging each one.
+func Run(ctx context.Context, m *Migrator, args []string, auto bool, w io.Writer) (done bool, err error) {
+	if len(args) > 0 && args[0] == "migrate" {
+		return true, Command(ctx, m, args[1:], w)
+	}
+	if !auto {
+		return false, nil
+	}
+	applied, err := m.Up(ctx)
+	for _, migration := range applied {
+		slog.Info("applied migration", "version", migration.Version, "name", migration.Name)
+	}
+	if err != nil {
+		return false, fmt.Errorf("migrating: %w", err)
+	}
+	return false, nil
+}
+
+// AutoFromEnv reports whether AUTO_MIGRATE holds a true value such as
+// "true" or "1"
+func AutoFromEnv() bool {
+	auto, _ := strconv.ParseBool(os.Getenv("AUTO_MIGRATE"))
+	return auto
+}
@@ -0,0 +1,3 @@
+module migrate
+
+go 1.24.3
@@ -0,0 +1,290 @@
+// Package migrate applies numbered SQL migrations to Postgres.
+//
+// Migrations are pairs of files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, usually embedded with embed.FS. Every service
+// records its applied versions in a table of its own, so services sharing a
+// database do not mistake each other's versions for theirs. A session-level
+// advisory lock derived from that table name is held while migrating, so
+// instances starting at the same time apply each migration exactly once.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether it has been applied
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Missing marks an applied version whose files no longer exist
+	Missing bool
+	// RecordedName is set when the version was applied under another name
+	RecordedName string
+}
+
+var (
+	fileNamePattern  = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+	tableNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+)
+
+// Load reads the migrations in dir of fsys, ordered by version. Every
+// version needs an up file; a missing down file makes Down fail for it.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		m := fileNamePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies a fixed set of migrations to one database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	table      string
+	// LockID is the advisory lock key, derived from the table name by New.
+	// Programs sharing the table share the key.
+	LockID int64
+}
+
+// New creates a Migrator for the migrations in dir of fsys. table records
+// the applied versions and must be unique to the service, for example
+// "billing_schema_migrations"; it is created on first use.
+func New(db *sql.DB, fsys fs.FS, dir, table string) (*Migrator, error) {
+	if !tableNamePattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid migrations table name %q", table)
+	}
+	migrations, err := Load(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		table:      table,
+		LockID:     lockID(table),
+	}, nil
+}
+
+// lockID hashes the table name into an advisory lock key
+func lockID(table string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("migrate:" + table))
+	return int64(h.Sum64())
+}
+
+// checkNames fails when a version was applied under another name than its
+// file has now, which means the files belong to another program or were
+// renumbered
+func (m *Migrator) checkNames(applied map[int64]Status) error {
+	for _, migration := range m.migrations {
+		status, ok := applied[migration.Version]
+		if ok && status.Name != migration.Name {
+			return fmt.Errorf("migration %d is recorded in %s as %s, not %s",
+				migration.Version, m.table, status.Name, migration.Name)
+		}
+	}
+	return nil
+}
+
+// Up applies every pending migration in version order, each in its own
+// transaction, and returns the ones it applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]Status) error {
+		if err := m.checkNames(applied); err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := m.apply(ctx, conn, migration.Up,
+				"INSERT INTO "+m.table+" (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and
+// returns the ones it reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]Status) error {
+		if err := m.checkNames(applied); err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+			}
+			err := m.apply(ctx, conn, migration.Down,
+				"DELETE FROM "+m.table+" WHERE version = $1", migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known migration and every applied version, ordered by
+// version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(_ *sql.Conn, applied map[int64]Status) error {
+		for _, migration := range m.migrations {
+			status, ok := applied[migration.Version]
+			if !ok {
+				status = Status{Version: migration.Version, Name: migration.Name}
+			} else if status.Name != migration.Name {
+				status.Name, status.RecordedName = migration.Name, status.Name
+			}
+			statuses = append(statuses, status)
+			delete(applied, migration.Version)
+		}
+		for _, status := range applied {
+			status.Missing = true
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, err
+}
+
+// locked runs fn on one connection holding the advisory lock, with the
+// versions applied so far
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn, map[int64]Status) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", m.LockID); err != nil {
+		return fmt.Errorf("taking migration lock: %w", err)
+	}
+	defer func() {
+		// The lock belongs to the session; a failed unlock must not put
+		// the connection back into the pool with the lock still held
+		if _, unlockErr := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", m.LockID); unlockErr != nil {
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+			err = errors.Join(err, fmt.Errorf("releasing migration lock: %w", unlockErr))
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.table+
+		" (version bigint PRIMARY KEY, name text NOT NULL, applied_at timestamptz NOT NULL DEFAULT now())")
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", m.table, err)
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, applied_at FROM "+m.table)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", m.table, err)
+	}
+	applied := map[int64]Status{}
+	for rows.Next() {
+		status := Status{Applied: true}
+		if err := rows.Scan(&status.Version, &status.Name, &status.AppliedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("reading %s: %w", m.table, err)
+		}
+		applied[status.Version] = status
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", m.table, err)
+	}
+
+	return fn(conn, applied)
+}
+
+// apply runs script and the bookkeeping statement in one transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"oracle-demo/models"
+	"oracle-demo/services"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// runBatchCommand implements
+//
+//	oracle-demo batch -segments LP,ND|all -from YYYYMMDD [-to YYYYMMDD] [-format csv|xml] [-concurrency N]
+//
+// It prints the BatchReport as JSON and returns the exit code: 0 when every
+// generation succeeded, 1 when any failed, 2 on bad arguments.
+func runBatchCommand(conns *models.ConnectionManager, svc *services.InvoiceService, args []string) int {
+	defer conns.Close()
+
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	segments := fs.String("segments", "all", "comma-separated segment codes, or all")
+	from := fs.String("from", "", "first invoice date, YYYYMMDD")
+	to := fs.String("to", "", "last invoice date, YYYYMMDD (defaults to -from)")
+	format := fs.String("format", "csv", "output format: csv or xml")
+	concurrency := fs.Int("concurrency", 2, "number of segments generated at once")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	outputFormat, err := services.ParseOutputFormat(*format)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	req := services.BatchRequest{
+		Segments:    strings.Split(*segments, ","),
+		From:        *from,
+		To:          *to,
+		Format:      outputFormat,
+		Concurrency: *concurrency,
+	}
+
+	// SIGINT/SIGTERM skips the remaining dates; running generations finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := svc.RunBatchC0401(ctx, req)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Print(err)
+		return 1
+	}
+	log.Printf("batch: %d succeeded, %d failed", report.Succeeded, report.Failed)
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
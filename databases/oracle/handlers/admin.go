@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"oracle-demo/models"
+	"oracle-demo/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	registry *models.SegmentRegistry
+	svc      *services.InvoiceService
+}
+
+// NewAdminHandler creates an AdminHandler. registry is nil when the service
+// replays fixtures.
+func NewAdminHandler(registry *models.SegmentRegistry, svc *services.InvoiceService) *AdminHandler {
+	return &AdminHandler{
+		registry: registry,
+		svc:      svc,
+	}
+}
+
+// SegmentsHandler serves GET /admin/segments
+//
+// Responds 200 with the configured segments, their pool settings and output
+// roots. DSN passwords are redacted.
+func (h *AdminHandler) SegmentsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		segments := []models.RedactedSegment{}
+		if h.registry != nil {
+			for _, segment := range h.registry.Segments() {
+				segments = append(segments, segment.Redacted())
+			}
+		} else {
+			for _, code := range h.svc.Segments() {
+				segments = append(segments, models.RedactedSegment{Code: code})
+			}
+		}
+		c.JSON(200, gin.H{"segments": segments})
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"log"
+	"net/http"
+	"oracle-demo/services"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern bounds client-supplied request IDs so they are safe to log
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID takes the request ID from X-Request-ID or generates one, and
+// echoes it in the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = rand.Text()
+		}
+		c.Set(RequestIDHeader, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// errorBody is the "error" member of every error response:
+//
+//	{"error": {"code": "DB_UNAVAILABLE", "kind": "upstream_db",
+//	 "message": "...", "ora_code": 12541}, "request_id": "..."}
+type errorBody struct {
+	Code    string             `json:"code"`
+	Kind    services.ErrorKind `json:"kind"`
+	Message string             `json:"message"`
+	OraCode int                `json:"ora_code,omitempty"`
+}
+
+// respondError aborts with the status and envelope of err. Internal and
+// storage details are logged, not returned.
+func respondError(c *gin.Context, err error, extra ...gin.H) {
+	e := services.AsError(err)
+	requestID := c.GetString(RequestIDHeader)
+
+	message := e.Error()
+	if e.Kind == services.KindInternal || e.Kind == services.KindStorage {
+		log.Printf("request %s: %s: %v", requestID, e.Code, err)
+		message = http.StatusText(e.Status)
+	}
+
+	body := gin.H{
+		"error":      errorBody{Code: e.Code, Kind: e.Kind, Message: message, OraCode: e.OraCode},
+		"request_id": requestID,
+	}
+	for _, h := range extra {
+		for k, v := range h {
+			body[k] = v
+		}
+	}
+	c.AbortWithStatusJSON(e.Status, body)
+}
+
+// badRequest is a 400 for a parameter the handler rejects itself
+func badRequest(code, message string) *services.Error {
+	return &services.Error{Kind: services.KindValidation, Code: code, Status: http.StatusBadRequest, Message: message}
+}
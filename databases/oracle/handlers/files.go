@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListFilesHandler serves GET /invoice/files/:segment_no
+//
+// Query parameters from and to (YYYYMMDD) bound the invoice date. Responds
+// 200 with the generated files of the segment.
+func (h *InvoiceHandler) ListFilesHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to := c.Query("from"), c.Query("to")
+		for _, d := range []string{from, to} {
+			if d == "" {
+				continue
+			}
+			if _, err := time.Parse("20060102", d); err != nil {
+				respondError(c, badRequest("INVALID_DATE", "from and to must be YYYYMMDD"))
+				return
+			}
+		}
+
+		files, err := h.svc.ListFiles(c.Param("segment_no"), from, to)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{"files": files})
+	}
+}
+
+// DownloadFileHandler serves GET /invoice/files/:segment_no/*name
+//
+// name is a file listed by ListFilesHandler or a file inside an XML
+// directory; directories are downloaded as zip. The SHA-256 of the body is
+// sent in X-Checksum-SHA256.
+func (h *InvoiceHandler) DownloadFileHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		download, err := h.svc.OpenDownload(c.Param("segment_no"), c.Param("name"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer download.Close()
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", download.Name))
+		c.Header("X-Checksum-SHA256", download.Checksum)
+		http.ServeContent(c.Writer, c.Request, download.Name, download.ModTime, download.File)
+	}
+}
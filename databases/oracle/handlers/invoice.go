@@ -1,25 +1,156 @@
 package handlers
 
 import (
+	"errors"
+	"oracle-demo/models"
 	"oracle-demo/services"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func GenerateC0401Handler(c *gin.Context) {
-	// 處理創建發票的邏輯
-	segment_no := c.Param("segment_no")
-	invoice_date := c.Query("invoice_date")
-	if invoice_date == "" {
-		invoice_date = time.Now().Format("20060102")
+type InvoiceHandler struct {
+	svc  *services.InvoiceService
+	jobs *services.JobManager
+}
+
+func NewInvoiceHandler(svc *services.InvoiceService, jobs *services.JobManager) *InvoiceHandler {
+	return &InvoiceHandler{
+		svc:  svc,
+		jobs: jobs,
 	}
+}
+
+// RequireKnownSegment rejects requests whose :segment_no is not in the
+// segment registry with 404
+func (h *InvoiceHandler) RequireKnownSegment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.svc.KnownSegment(c.Param("segment_no")) {
+			respondError(c, &models.UnknownSegmentError{Segment: c.Param("segment_no")})
+			return
+		}
+		c.Next()
+	}
+}
+
+// GenerateC0401Handler serves POST /invoice/gen_c0401/:segment_no and
+// queues a C0401 (issue) generation job
+//
+// Query parameters:
+//   - invoice_date: YYYYMMDD, defaults to today
+//   - format: csv (default) or xml
+//
+// Responds 202 with the queued job, 400 on bad parameters and 409 with the
+// existing job when one is already queued or running for the segment/date.
+func (h *InvoiceHandler) GenerateC0401Handler() gin.HandlerFunc {
+	return h.generateHandler(services.MessageC0401)
+}
+
+// GenerateC0501Handler serves POST /invoice/gen_c0501/:segment_no and
+// queues a C0501 (cancellation) generation job. invoice_date selects the
+// day the cancellations were made; otherwise it behaves like
+// GenerateC0401Handler. Responds 501 when no C0501 procedure and query are
+// configured.
+func (h *InvoiceHandler) GenerateC0501Handler() gin.HandlerFunc {
+	return h.generateHandler(services.MessageC0501)
+}
+
+// GenerateC0701Handler serves POST /invoice/gen_c0701/:segment_no and
+// queues a C0701 (void) generation job. invoice_date selects the day the
+// voids were made; otherwise it behaves like GenerateC0401Handler.
+// Responds 501 when no C0701 procedure and query are configured.
+func (h *InvoiceHandler) GenerateC0701Handler() gin.HandlerFunc {
+	return h.generateHandler(services.MessageC0701)
+}
+
+func (h *InvoiceHandler) generateHandler(messageType services.MessageType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 處理創建發票的邏輯
+		// Segments are case-insensitive; outputs live under the upper-case name
+		segment_no := strings.ToUpper(c.Param("segment_no"))
+		invoice_date := c.Query("invoice_date")
+		if invoice_date == "" {
+			invoice_date = time.Now().Format("20060102")
+		}
+		if _, err := time.Parse("20060102", invoice_date); err != nil {
+			respondError(c, badRequest("INVALID_DATE", "invoice_date must be YYYYMMDD"))
+			return
+		}
+
+		format, err := services.ParseOutputFormat(c.Query("format"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if err := h.svc.CheckSupported(messageType); err != nil {
+			respondError(c, err)
+			return
+		}
+
+		job, err := h.jobs.Enqueue(messageType, segment_no, invoice_date, format)
+		var inProgress *services.JobInProgressError
+		if errors.As(err, &inProgress) {
+			respondError(c, err, gin.H{"job": job})
+			return
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.Header("Location", "/invoice/jobs/"+job.ID)
+		c.JSON(202, job)
+	}
+}
+
+// BatchC0401Handler serves POST /invoice/batch/c0401 and queues a batch
+// generation over segments and an invoice date range
+//
+// Body: {"segments": ["LP", "ND"] or ["all"], "from": "YYYYMMDD",
+// "to": "YYYYMMDD", "format": "csv"|"xml", "concurrency": 2}
+//
+// Responds 202 with the queued job, whose batch_report carries the
+// per-segment/date outcome once finished, 400 on a bad request or 404 for
+// an unknown segment.
+func (h *InvoiceHandler) BatchC0401Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req services.BatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, badRequest("INVALID_BODY", err.Error()))
+			return
+		}
+
+		format, err := services.ParseOutputFormat(string(req.Format))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		req.Format = format
+
+		job, err := h.jobs.EnqueueBatch(req)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.Header("Location", "/invoice/jobs/"+job.ID)
+		c.JSON(202, job)
+	}
+}
 
-	// Call the handler to generate the invoice
-	invoice_data, err := services.GenerateC0401Invoice(segment_no, invoice_date)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to generate invoice"})
-		return
+// JobStatusHandler serves GET /invoice/jobs/:id
+//
+// Responds 200 with the job state (queued/running/succeeded/failed), its
+// CSVGenerationResult once finished and the error of a failed job, or 404.
+func (h *InvoiceHandler) JobStatusHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := h.jobs.Get(c.Param("id"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(200, job)
 	}
-	c.JSON(200, gin.H{"data": invoice_data})
 }
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oracle-demo/services"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestServer wires the invoice routes as main does, over the fixtures in
+// testdata/fixtures
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	source, err := services.NewFixtureInvoiceSource("testdata/fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := services.NewInvoiceService(t.TempDir(), source)
+	jobs := services.NewJobManager(svc, 1, time.Hour)
+	h := NewInvoiceHandler(svc, jobs)
+
+	r := gin.New()
+	r.Use(RequestID())
+	r.POST("/invoice/gen_c0401/:segment_no", h.RequireKnownSegment(), h.GenerateC0401Handler())
+	r.GET("/invoice/jobs/:id", h.JobStatusHandler())
+	r.GET("/invoice/files/:segment_no", h.ListFilesHandler())
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(func() {
+		srv.Close()
+		jobs.Wait()
+	})
+	return srv
+}
+
+// do sends a request and decodes the JSON response into v
+func do(t *testing.T, method, url string, v any) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: decoding response: %v", method, url, err)
+	}
+	return resp
+}
+
+// waitJob polls a job until it leaves queued/running and fails the test
+// unless it succeeded
+func waitJob(t *testing.T, srv *httptest.Server, id string) services.Job {
+	t.Helper()
+	var job services.Job
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := do(t, http.MethodGet, srv.URL+"/invoice/jobs/"+id, &job)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET status = %d, want 200", resp.StatusCode)
+		}
+		if job.State == services.JobSucceeded || job.State == services.JobFailed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s after 5s", job.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.State != services.JobSucceeded {
+		t.Fatalf("job %s: %s %s", job.State, job.ErrorCode, job.Error)
+	}
+	return job
+}
+
+func TestGenerateC0401Job(t *testing.T) {
+	srv := newTestServer(t)
+
+	var queued services.Job
+	resp := do(t, http.MethodPost, srv.URL+"/invoice/gen_c0401/LP?invoice_date=20250701", &queued)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST status = %d, want 202", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/invoice/jobs/"+queued.ID {
+		t.Errorf("Location = %q, want /invoice/jobs/%s", loc, queued.ID)
+	}
+	if queued.SegmentNo != "LP" || queued.InvoiceDate != "20250701" || queued.Format != services.FormatCSV {
+		t.Errorf("queued job = %+v", queued)
+	}
+
+	job := waitJob(t, srv, queued.ID)
+	if job.Result.InvoiceCount != 1 || job.Result.TotalRows != 2 {
+		t.Errorf("result = %d invoices, %d rows, want 1 and 2", job.Result.InvoiceCount, job.Result.TotalRows)
+	}
+	if _, err := os.Stat(job.Result.FilePath); err != nil {
+		t.Errorf("output file: %v", err)
+	}
+}
+
+// A segment given in lower case is generated into, and listed from, the
+// same upper-case directory
+func TestGenerateLowerCaseSegment(t *testing.T) {
+	srv := newTestServer(t)
+
+	var queued services.Job
+	resp := do(t, http.MethodPost, srv.URL+"/invoice/gen_c0401/lp?invoice_date=20250701", &queued)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST status = %d, want 202", resp.StatusCode)
+	}
+	if queued.SegmentNo != "LP" {
+		t.Errorf("job segment = %q, want LP", queued.SegmentNo)
+	}
+	job := waitJob(t, srv, queued.ID)
+	if dir := filepath.Base(filepath.Dir(job.Result.FilePath)); dir != "LP" {
+		t.Errorf("output written under %q, want LP", dir)
+	}
+
+	for _, segment := range []string{"LP", "lp"} {
+		var body struct {
+			Files []services.GeneratedFile `json:"files"`
+		}
+		do(t, http.MethodGet, srv.URL+"/invoice/files/"+segment, &body)
+		if len(body.Files) != 1 || body.Files[0].Name != "C0401-20250701-LP.csv" {
+			t.Errorf("files of %s = %+v", segment, body.Files)
+		}
+	}
+
+}
+
+func TestGenerateC0401Errors(t *testing.T) {
+	srv := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{"unknown segment", http.MethodPost, "/invoice/gen_c0401/ZZ?invoice_date=20250701", http.StatusNotFound, "UNKNOWN_SEGMENT"},
+		{"bad date", http.MethodPost, "/invoice/gen_c0401/LP?invoice_date=2025-07-01", http.StatusBadRequest, "INVALID_DATE"},
+		{"unknown job", http.MethodGet, "/invoice/jobs/nope", http.StatusNotFound, "JOB_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body struct {
+				Error     errorBody `json:"error"`
+				RequestID string    `json:"request_id"`
+			}
+			resp := do(t, tt.method, srv.URL+tt.path, &body)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if body.Error.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Error.Code, tt.code)
+			}
+			if body.RequestID == "" || body.RequestID != resp.Header.Get(RequestIDHeader) {
+				t.Errorf("request_id = %q, header %q", body.RequestID, resp.Header.Get(RequestIDHeader))
+			}
+		})
+	}
+}
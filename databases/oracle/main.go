@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"oracle-demo/handlers"
 	"oracle-demo/models"
+	"oracle-demo/services"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	_ "github.com/sijms/go-ora/v2"
 )
 
 func main() {
@@ -17,32 +25,350 @@ func main() {
 		log.Printf("cannot find .env file: %v", err)
 	}
 
-	oracle_dsn_lp := os.Getenv("ORACLE_DSN_LP")
-	if oracle_dsn_lp == "" {
-		log.Fatal("ORACLE_DSN_LP is not set")
+	conns, registry, invoiceService := setup()
+
+	// `oracle-demo batch ...` runs a batch generation and exits
+	if len(os.Args) > 1 && os.Args[1] == "batch" {
+		os.Exit(runBatchCommand(conns, invoiceService, os.Args[2:]))
+	}
+
+	serve(conns, registry, invoiceService)
+}
+
+// setup loads the segment registry (or the fixtures) and builds the
+// InvoiceService from the environment. The registry is nil in fixture mode.
+func setup() (*models.ConnectionManager, *models.SegmentRegistry, *services.InvoiceService) {
+	var err error
+	rootDir := os.Getenv("INVOICE_ROOT_DIR")
+	if rootDir == "" {
+		rootDir = "."
 	}
-	models.OracleConfigs["LP"] = models.OracleConfig{
-		DSN: oracle_dsn_lp,
+
+	conns := models.NewConnectionManager()
+
+	// INVOICE_FIXTURE_DIR replays recorded rows instead of querying Oracle
+	var source services.InvoiceSource
+	var registry *models.SegmentRegistry
+	if fixtureDir := os.Getenv("INVOICE_FIXTURE_DIR"); fixtureDir != "" {
+		source, err = services.NewFixtureInvoiceSource(fixtureDir)
+		if err != nil {
+			log.Fatalf("Error loading fixtures: %v", err)
+		}
+	} else {
+		registry, err = loadSegmentRegistry()
+		if err != nil {
+			log.Fatal(err)
+		}
+		conns.Sync(registry.Segments())
+		oracleSource := services.NewOracleInvoiceSource(conns)
+		if err := configureReports(oracleSource); err != nil {
+			log.Fatal(err)
+		}
+		source = oracleSource
 	}
 
-	oracle_dsn_nd := os.Getenv("ORACLE_DSN_ND")
-	if oracle_dsn_nd == "" {
-		log.Fatal("ORACLE_DSN_ND is not set")
+	invoiceService := services.NewInvoiceService(rootDir, source)
+	invoiceService.SetStrictValidation(os.Getenv("INVOICE_STRICT_VALIDATION") == "true")
+	switch policy := services.RegeneratePolicy(os.Getenv("INVOICE_REGENERATE_POLICY")); policy {
+	case "":
+	case services.RegenerateVersion, services.RegenerateRefuse:
+		invoiceService.SetRegeneratePolicy(policy)
+	default:
+		log.Fatalf("INVOICE_REGENERATE_POLICY: unsupported policy %q", policy)
+	}
+	rarePolicy := services.RareCharPolicy{
+		Mode:       services.RareCharMode(os.Getenv("INVOICE_RARE_CHAR_POLICY")),
+		Substitute: os.Getenv("INVOICE_RARE_CHAR_SUBSTITUTE"),
 	}
-	models.OracleConfigs["ND"] = models.OracleConfig{
-		DSN: oracle_dsn_nd,
+	switch rarePolicy.Mode {
+	case "", services.RareCharPass, services.RareCharReplace, services.RareCharReject:
+	default:
+		log.Fatalf("INVOICE_RARE_CHAR_POLICY: unsupported mode %q", rarePolicy.Mode)
+	}
+	if mapFile := os.Getenv("INVOICE_RARE_CHAR_MAP"); mapFile != "" {
+		rarePolicy.Mapping, err = services.LoadRareCharMapping(mapFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	invoiceService.SetRareCharPolicy(rarePolicy)
+	if registry != nil {
+		invoiceService.SetSegmentRootDirs(segmentRootDirs(registry.Segments()))
+	}
+	issuers, err := segmentIssuers(invoiceService.Segments(), registry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	invoiceService.SetSegmentIssuers(issuers)
+
+	return conns, registry, invoiceService
+}
+
+// configureReports reads INVOICE_<TYPE>_PROCEDURE and
+// INVOICE_<TYPE>_QUERY_FILE for C0501 and C0701. A message type with
+// neither set stays unavailable; its route answers 501.
+func configureReports(source *services.OracleInvoiceSource) error {
+	for _, messageType := range []services.MessageType{services.MessageC0501, services.MessageC0701} {
+		procedure := os.Getenv("INVOICE_" + string(messageType) + "_PROCEDURE")
+		queryFile := os.Getenv("INVOICE_" + string(messageType) + "_QUERY_FILE")
+		if procedure == "" && queryFile == "" {
+			continue
+		}
+		if procedure == "" || queryFile == "" {
+			return fmt.Errorf("set both INVOICE_%[1]s_PROCEDURE and INVOICE_%[1]s_QUERY_FILE", messageType)
+		}
+		query, err := os.ReadFile(queryFile)
+		if err != nil {
+			return fmt.Errorf("INVOICE_%s_QUERY_FILE: %w", messageType, err)
+		}
+		report := services.OracleReport{Procedure: procedure, Query: string(query)}
+		if err := source.SetReport(messageType, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSegmentRegistry reads INVOICE_SEGMENTS_FILE, or builds the registry
+// from the legacy ORACLE_DSN_LP / ORACLE_DSN_ND variables when it is unset
+func loadSegmentRegistry() (*models.SegmentRegistry, error) {
+	if path := os.Getenv("INVOICE_SEGMENTS_FILE"); path != "" {
+		return models.LoadSegmentRegistry(path)
+	}
+
+	var segments []models.SegmentConfig
+	for _, code := range []string{"LP", "ND"} {
+		if dsn := os.Getenv("ORACLE_DSN_" + code); dsn != "" {
+			segments = append(segments, models.SegmentConfig{
+				Code: code,
+				DSN:  dsn,
+				Pool: poolConfigFromEnv(code),
+			})
+		}
+	}
+	if len(segments) == 0 {
+		return nil, errors.New("set INVOICE_SEGMENTS_FILE or ORACLE_DSN_LP / ORACLE_DSN_ND")
+	}
+	return models.NewSegmentRegistry(segments)
+}
+
+// segmentRootDirs maps segments to their configured output roots
+func segmentRootDirs(segments []models.SegmentConfig) map[string]string {
+	roots := make(map[string]string)
+	for _, segment := range segments {
+		if segment.OutputRoot != "" {
+			roots[segment.Code] = segment.OutputRoot
+		}
+	}
+	return roots
+}
+
+// segmentIssuers returns the seller and invoice type of every segment's
+// C0401 XML: the registry entry, or for segments without a seller there
+// INVOICE_SELLER_ID_<SEG>, INVOICE_SELLER_NAME_<SEG>,
+// INVOICE_SELLER_ADDRESS_<SEG> and INVOICE_TYPE_<SEG>. registry is nil in
+// fixture mode.
+func segmentIssuers(codes []string, registry *models.SegmentRegistry) (map[string]services.SegmentIssuer, error) {
+	issuers := make(map[string]services.SegmentIssuer)
+	for _, code := range codes {
+		var segment models.SegmentConfig
+		if registry != nil {
+			segment, _ = registry.Lookup(code)
+		}
+		if segment.Seller == (models.SellerConfig{}) {
+			segment.Seller = models.SellerConfig{
+				Identifier: os.Getenv("INVOICE_SELLER_ID_" + code),
+				Name:       os.Getenv("INVOICE_SELLER_NAME_" + code),
+				Address:    os.Getenv("INVOICE_SELLER_ADDRESS_" + code),
+			}
+			if err := segment.Seller.Validate(); err != nil {
+				return nil, fmt.Errorf("INVOICE_SELLER_*_%s: %w", code, err)
+			}
+		}
+		if segment.InvoiceType == "" {
+			segment.InvoiceType = os.Getenv("INVOICE_TYPE_" + code)
+			if err := models.ValidateInvoiceType(segment.InvoiceType); err != nil {
+				return nil, fmt.Errorf("INVOICE_TYPE_%s: %w", code, err)
+			}
+		}
+		issuers[code] = services.SegmentIssuer{Seller: segment.Seller, InvoiceType: segment.InvoiceType}
+	}
+	return issuers, nil
+}
+
+// reloadSegments re-reads the registry on every SIGHUP and applies it to the
+// pools and output roots. A broken file keeps the previous segments.
+func reloadSegments(ctx context.Context, registry *models.SegmentRegistry, conns *models.ConnectionManager, svc *services.InvoiceService) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+
+		segments, err := registry.Reload()
+		if err != nil {
+			log.Printf("reloading segments: %v", err)
+			continue
+		}
+		codes := make([]string, 0, len(segments))
+		for _, segment := range segments {
+			codes = append(codes, segment.Code)
+		}
+		issuers, err := segmentIssuers(codes, registry)
+		if err != nil {
+			log.Printf("reloading segments: %v", err)
+			continue
+		}
+		conns.Sync(segments)
+		svc.SetSegmentRootDirs(segmentRootDirs(segments))
+		svc.SetSegmentIssuers(issuers)
+		log.Printf("reloaded %d segments", len(segments))
 	}
+}
 
-	// for key, dsn := range models.OracleConfigs {
-	// 	log.Printf("Oracle DSN for %s: %s", key, dsn.DSN)
-	// }
+// serve runs the HTTP API until SIGINT/SIGTERM
+func serve(conns *models.ConnectionManager, registry *models.SegmentRegistry, invoiceService *services.InvoiceService) {
+	jobs := services.NewJobManager(invoiceService, intFromEnv("INVOICE_JOB_WORKERS", 2), 24*time.Hour)
+	invoiceHandler := handlers.NewInvoiceHandler(invoiceService, jobs)
+	adminHandler := handlers.NewAdminHandler(registry, invoiceService)
 
 	r := gin.Default()
+	r.Use(handlers.RequestID())
 	r.GET("/ping", func(c *gin.Context) {
 		c.String(200, "pong")
 	})
 
-	r.GET("/invoice/gen_c0401/:segment_no", handlers.GenerateC0401Handler)
+	r.GET("/health", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		segments := conns.Health(ctx)
+		status := 200
+		for _, h := range segments {
+			if !h.Healthy {
+				status = 503
+			}
+		}
+		c.JSON(status, gin.H{"segments": segments})
+	})
+
+	knownSegment := invoiceHandler.RequireKnownSegment()
+	r.POST("/invoice/gen_c0401/:segment_no", knownSegment, invoiceHandler.GenerateC0401Handler())
+	r.POST("/invoice/gen_c0501/:segment_no", knownSegment, invoiceHandler.GenerateC0501Handler())
+	r.POST("/invoice/gen_c0701/:segment_no", knownSegment, invoiceHandler.GenerateC0701Handler())
+	r.POST("/invoice/batch/c0401", invoiceHandler.BatchC0401Handler())
+	r.GET("/invoice/jobs/:id", invoiceHandler.JobStatusHandler())
+	r.GET("/invoice/files/:segment_no", knownSegment, invoiceHandler.ListFilesHandler())
+	r.GET("/invoice/files/:segment_no/*name", knownSegment, invoiceHandler.DownloadFileHandler())
+	r.GET("/admin/segments", adminHandler.SegmentsHandler())
+
+	srv := &http.Server{
+		Addr:    "0.0.0.0:8080",
+		Handler: r,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("listen: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if registry != nil {
+		go reloadSegments(ctx, registry, conns, invoiceService)
+	}
+
+	if days := intFromEnv("INVOICE_RETENTION_DAYS", 0); days > 0 {
+		policy := services.RetentionPolicy{
+			Mode:   services.RetentionMode(os.Getenv("INVOICE_RETENTION_MODE")),
+			MaxAge: time.Duration(days) * 24 * time.Hour,
+		}
+		if policy.Mode == "" {
+			policy.Mode = services.RetentionArchive
+		}
+		go runRetention(ctx, invoiceService, policy)
+	}
+
+	// Wait for SIGINT/SIGTERM, drain in-flight requests and jobs, then close the pools
+	<-ctx.Done()
+	log.Println("shutting down")
 
-	r.Run("0.0.0.0:8080")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("server shutdown: %v", err)
+	}
+	jobs.Wait()
+	if err := conns.Close(); err != nil {
+		log.Printf("closing Oracle pools: %v", err)
+	}
+}
+
+// poolConfigFromEnv reads ORACLE_MAX_OPEN_CONNS_<SEG>, ORACLE_MAX_IDLE_CONNS_<SEG>
+// and ORACLE_CONN_MAX_LIFETIME_<SEG> (a Go duration)
+func poolConfigFromEnv(segment string) models.PoolConfig {
+	var pool models.PoolConfig
+	if v := os.Getenv("ORACLE_MAX_OPEN_CONNS_" + segment); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("ORACLE_MAX_OPEN_CONNS_%s: %v", segment, err)
+		}
+		pool.MaxOpenConns = n
+	}
+	if v := os.Getenv("ORACLE_MAX_IDLE_CONNS_" + segment); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("ORACLE_MAX_IDLE_CONNS_%s: %v", segment, err)
+		}
+		pool.MaxIdleConns = n
+	}
+	if v := os.Getenv("ORACLE_CONN_MAX_LIFETIME_" + segment); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("ORACLE_CONN_MAX_LIFETIME_%s: %v", segment, err)
+		}
+		pool.ConnMaxLifetime = d
+	}
+	return pool
+}
+
+// intFromEnv reads an integer environment variable, falling back to def
+func intFromEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return n
+}
+
+// runRetention applies the retention policy at startup and once a day
+func runRetention(ctx context.Context, svc *services.InvoiceService, policy services.RetentionPolicy) {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for {
+		report, err := svc.ApplyRetention(policy)
+		if err != nil {
+			log.Printf("retention: %v", err)
+		}
+		if report != nil {
+			log.Printf("retention: archived %d, deleted %d", len(report.Archived), len(report.Deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
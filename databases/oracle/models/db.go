@@ -1,30 +1,278 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/sijms/go-ora/v2"
 )
 
+// PoolConfig holds the database/sql pool limits of a segment.
+// Zero values leave the database/sql defaults in place.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
 type OracleConfig struct {
-	DSN string
+	DSN  string
+	Pool PoolConfig
 }
 
-var OracleConfigs = make(map[string]OracleConfig)
+// UnknownSegmentError is returned for a segment that has no configuration
+type UnknownSegmentError struct {
+	Segment string
+}
 
-func GetOracleConnection(segment_no string) (*sql.DB, error) {
-	// the config will always be available because it is set in main
-	config := OracleConfigs[strings.ToUpper(segment_no)]
-	db, err := sql.Open("oracle", config.DSN)
-	if err != nil {
-		log.Printf("Error opening Oracle connection: %v", err)
+func (e *UnknownSegmentError) Error() string {
+	return fmt.Sprintf("unknown segment: %q", e.Segment)
+}
 
-		return nil, err
+// SegmentHealth reports the state of one segment's pool
+type SegmentHealth struct {
+	Segment         string `json:"segment"`
+	Open            bool   `json:"open"`
+	Healthy         bool   `json:"healthy"`
+	Error           string `json:"error,omitempty"`
+	OpenConnections int    `json:"open_connections"`
+	InUse           int    `json:"in_use"`
+	Idle            int    `json:"idle"`
+}
+
+// driverName is the database/sql driver of the segment pools
+var driverName = "oracle"
+
+// segmentPool opens the segment's *sql.DB lazily, exactly once. users
+// counts the Acquire calls not released yet; a pool retired by Sync is
+// closed when the last of them is released.
+type segmentPool struct {
+	config OracleConfig
+	once   sync.Once
+	db     *sql.DB
+	err    error
+
+	mu      sync.Mutex
+	users   int
+	retired bool
+}
+
+// open opens the pool on first use
+func (p *segmentPool) open() (*sql.DB, error) {
+	p.once.Do(func() {
+		db, err := sql.Open(driverName, p.config.DSN)
+		if err != nil {
+			log.Printf("Error opening Oracle connection: %v", err)
+			p.err = err
+			return
+		}
+		db.SetMaxOpenConns(p.config.Pool.MaxOpenConns)
+		if p.config.Pool.MaxIdleConns > 0 {
+			db.SetMaxIdleConns(p.config.Pool.MaxIdleConns)
+		}
+		db.SetConnMaxLifetime(p.config.Pool.ConnMaxLifetime)
+		db.SetConnMaxIdleTime(p.config.Pool.ConnMaxIdleTime)
+		p.db = db
+	})
+	return p.db, p.err
+}
+
+// retire marks a pool replaced by Sync and closes it right away when
+// nobody holds it
+func (p *segmentPool) retire(code string) {
+	p.mu.Lock()
+	p.retired = true
+	idle := p.users == 0
+	p.mu.Unlock()
+	if idle {
+		p.close(code)
+	}
+}
+
+// release ends one Acquire, closing a retired pool with its last user
+func (p *segmentPool) release(code string) {
+	p.mu.Lock()
+	p.users--
+	idle := p.retired && p.users == 0
+	p.mu.Unlock()
+	if idle {
+		p.close(code)
+	}
+}
+
+func (p *segmentPool) close(code string) {
+	if p.db == nil {
+		return
+	}
+	// db.Close waits for running queries, so do not block reloads on it
+	go func() {
+		if err := p.db.Close(); err != nil {
+			log.Printf("closing replaced pool of segment %s: %v", code, err)
+		}
+	}()
+}
+
+// ConnectionManager keeps one pooled *sql.DB per segment (LP, ND, ...)
+type ConnectionManager struct {
+	mu     sync.RWMutex
+	pools  map[string]*segmentPool
+	closed bool
+}
+
+// NewConnectionManager creates an empty ConnectionManager
+func NewConnectionManager() *ConnectionManager {
+	return &ConnectionManager{
+		pools: make(map[string]*segmentPool),
+	}
+}
+
+// Register adds the configuration of a segment. The pool is opened on first use.
+func (m *ConnectionManager) Register(segment string, config OracleConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pools[strings.ToUpper(segment)] = &segmentPool{config: config}
+}
+
+// Sync replaces the registered segments with the registry entries. Pools of
+// removed segments or of segments whose DSN or pool settings changed are
+// closed once every Acquire of them has been released; unchanged pools are
+// kept.
+func (m *ConnectionManager) Sync(segments []SegmentConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pools := make(map[string]*segmentPool, len(segments))
+	for _, segment := range segments {
+		code := strings.ToUpper(segment.Code)
+		config := OracleConfig{DSN: segment.DSN, Pool: segment.Pool}
+		if pool, ok := m.pools[code]; ok && pool.config == config {
+			pools[code] = pool
+			continue
+		}
+		pools[code] = &segmentPool{config: config}
+	}
+
+	for code, pool := range m.pools {
+		if pools[code] != pool {
+			pool.retire(code)
+		}
 	}
-	err = db.Ping()
+	m.pools = pools
+}
+
+// Segments lists the registered segment codes
+func (m *ConnectionManager) Segments() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	segments := make([]string, 0, len(m.pools))
+	for segment := range m.pools {
+		segments = append(segments, segment)
+	}
+	sort.Strings(segments)
+	return segments
+}
+
+// DB returns the pool of a segment, opening it on first use. A later Sync
+// may close the pool; callers that keep it, such as a session pinning a
+// connection, use Acquire instead.
+func (m *ConnectionManager) DB(segment string) (*sql.DB, error) {
+	// Held for the whole call so Close cannot race with a pool being opened
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pool, err := m.pool(segment)
 	if err != nil {
-		log.Printf("Error pinging Oracle database: %v", err)
 		return nil, err
 	}
-	return db, nil
+	return pool.open()
+}
+
+// Acquire returns the pool of a segment like DB and keeps it open, even
+// when Sync replaces it meanwhile, until release is called. release may be
+// called more than once.
+func (m *ConnectionManager) Acquire(segment string) (db *sql.DB, release func(), err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pool, err := m.pool(segment)
+	if err != nil {
+		return nil, nil, err
+	}
+	if db, err = pool.open(); err != nil {
+		return nil, nil, err
+	}
+
+	pool.mu.Lock()
+	pool.users++
+	pool.mu.Unlock()
+	code := strings.ToUpper(segment)
+	return db, sync.OnceFunc(func() { pool.release(code) }), nil
+}
+
+// pool looks up a segment; m.mu must be held
+func (m *ConnectionManager) pool(segment string) (*segmentPool, error) {
+	if m.closed {
+		return nil, errors.New("connection manager is closed")
+	}
+	pool, ok := m.pools[strings.ToUpper(segment)]
+	if !ok {
+		return nil, &UnknownSegmentError{Segment: segment}
+	}
+	return pool, nil
+}
+
+// Health pings every registered segment. Segments whose pool has not been
+// opened yet are opened so that misconfiguration shows up here.
+func (m *ConnectionManager) Health(ctx context.Context) []SegmentHealth {
+	var health []SegmentHealth
+	for _, segment := range m.Segments() {
+		h := SegmentHealth{Segment: segment}
+		db, release, err := m.Acquire(segment)
+		if err != nil {
+			h.Error = err.Error()
+			health = append(health, h)
+			continue
+		}
+		h.Open = true
+
+		if err := db.PingContext(ctx); err != nil {
+			h.Error = err.Error()
+		} else {
+			h.Healthy = true
+		}
+
+		stats := db.Stats()
+		h.OpenConnections = stats.OpenConnections
+		h.InUse = stats.InUse
+		h.Idle = stats.Idle
+		release()
+		health = append(health, h)
+	}
+	return health
+}
+
+// Close closes every opened pool. The manager cannot be used afterwards.
+func (m *ConnectionManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+
+	var errs []error
+	for segment, pool := range m.pools {
+		if pool.db == nil {
+			continue
+		}
+		if err := pool.db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing segment %s: %w", segment, err))
+		}
+	}
+	return errors.Join(errs...)
 }
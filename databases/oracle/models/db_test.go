@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDriver accepts any DSN and every statement, so that pools can be
+// opened, pinned and closed without Oracle
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.ResultNoRows, nil
+}
+
+func init() {
+	sql.Register("fake-oracle", fakeDriver{})
+	driverName = "fake-oracle"
+}
+
+// isClosed reports whether db was closed
+func isClosed(db *sql.DB) bool {
+	err := db.PingContext(context.Background())
+	return err != nil && strings.Contains(err.Error(), "database is closed")
+}
+
+// waitClosed waits for the asynchronous close of a replaced pool
+func waitClosed(t *testing.T, db *sql.DB) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !isClosed(db) {
+		if time.Now().After(deadline) {
+			t.Fatal("replaced pool was not closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSyncDuringGeneration(t *testing.T) {
+	m := NewConnectionManager()
+	defer m.Close()
+	m.Sync([]SegmentConfig{{Code: "LP", DSN: "oracle://old"}})
+	ctx := context.Background()
+
+	// A generation pins a connection of the pool, as oracleSession does
+	db, release, err := m.Acquire("lp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN P_SET_SEGMENT_NO('LP'); END;"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The segment's DSN changes in the middle of it
+	m.Sync([]SegmentConfig{{Code: "LP", DSN: "oracle://new"}})
+	newDB, err := m.DB("LP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newDB == db {
+		t.Fatal("Sync kept the pool of a changed DSN")
+	}
+
+	// The rest of the generation still runs on the old pool
+	time.Sleep(20 * time.Millisecond)
+	if isClosed(db) {
+		t.Fatal("replaced pool closed while a session holds it")
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT 1 FROM dual"); err != nil {
+		t.Fatalf("query after the reload: %v", err)
+	}
+
+	conn.Close()
+	release()
+	release() // a second release must not close anything else
+	waitClosed(t, db)
+	if isClosed(newDB) {
+		t.Error("the new pool was closed")
+	}
+}
+
+func TestSyncClosesIdlePools(t *testing.T) {
+	m := NewConnectionManager()
+	defer m.Close()
+	m.Sync([]SegmentConfig{{Code: "LP", DSN: "oracle://lp"}, {Code: "ND", DSN: "oracle://nd"}})
+
+	lp, err := m.DB("LP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := m.DB("ND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ND is removed, LP is unchanged
+	m.Sync([]SegmentConfig{{Code: "LP", DSN: "oracle://lp"}})
+	waitClosed(t, nd)
+	if again, _ := m.DB("LP"); again != lp || isClosed(lp) {
+		t.Error("unchanged pool was replaced or closed")
+	}
+	var unknown *UnknownSegmentError
+	if _, _, err := m.Acquire("ND"); !errors.As(err, &unknown) {
+		t.Errorf("Acquire of a removed segment: %v", err)
+	}
+}
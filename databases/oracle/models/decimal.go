@@ -0,0 +1,165 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxDecimalPlaces bounds String for values that are not finite decimals,
+// which only division could produce
+const maxDecimalPlaces = 20
+
+// Decimal is an exact decimal number for monetary amounts. It never goes
+// through float64, so sums and products are exact. The zero value is 0.
+type Decimal struct {
+	r *big.Rat
+}
+
+// NewDecimal returns the Decimal for an integer
+func NewDecimal(n int64) Decimal {
+	return Decimal{r: new(big.Rat).SetInt64(n)}
+}
+
+// ParseDecimal parses a plain decimal literal such as "-12.3400"
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsAny(s, "/eE") {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal{r: r}, nil
+}
+
+func (d Decimal) rat() *big.Rat {
+	if d.r == nil {
+		return new(big.Rat)
+	}
+	return d.r
+}
+
+// Add returns d + e
+func (d Decimal) Add(e Decimal) Decimal {
+	return Decimal{r: new(big.Rat).Add(d.rat(), e.rat())}
+}
+
+// Sub returns d - e
+func (d Decimal) Sub(e Decimal) Decimal {
+	return Decimal{r: new(big.Rat).Sub(d.rat(), e.rat())}
+}
+
+// Mul returns d × e
+func (d Decimal) Mul(e Decimal) Decimal {
+	return Decimal{r: new(big.Rat).Mul(d.rat(), e.rat())}
+}
+
+// Abs returns |d|
+func (d Decimal) Abs() Decimal {
+	return Decimal{r: new(big.Rat).Abs(d.rat())}
+}
+
+// Cmp compares d and e and returns -1, 0 or +1
+func (d Decimal) Cmp(e Decimal) int {
+	return d.rat().Cmp(e.rat())
+}
+
+// Equal reports whether d == e
+func (d Decimal) Equal(e Decimal) bool {
+	return d.Cmp(e) == 0
+}
+
+// IsZero reports whether d == 0
+func (d Decimal) IsZero() bool {
+	return d.rat().Sign() == 0
+}
+
+// Round rounds d to places decimal places, halves away from zero
+func (d Decimal) Round(places int) Decimal {
+	r, _ := new(big.Rat).SetString(d.rat().FloatString(places))
+	return Decimal{r: r}
+}
+
+// StringFixed formats d with exactly places decimal places, rounding halves
+// away from zero
+func (d Decimal) StringFixed(places int) string {
+	return d.rat().FloatString(places)
+}
+
+// StringMax formats d with at most places decimal places, rounding halves
+// away from zero and dropping trailing zeros
+func (d Decimal) StringMax(places int) string {
+	s := d.rat().FloatString(places)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+// String formats d exactly, without trailing zeros
+func (d Decimal) String() string {
+	return d.StringMax(maxDecimalPlaces)
+}
+
+// NullDecimal is a Decimal that may be NULL, like sql.NullString
+type NullDecimal struct {
+	Decimal Decimal
+	Valid   bool
+}
+
+// Scan implements sql.Scanner. go-ora delivers NUMBER columns as exact
+// decimal strings; integer and float sources are accepted as well.
+func (n *NullDecimal) Scan(src any) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*n = NullDecimal{}
+		return nil
+	case string:
+		n.Decimal, err = ParseDecimal(v)
+	case []byte:
+		n.Decimal, err = ParseDecimal(string(v))
+	case int64:
+		n.Decimal = NewDecimal(v)
+	case float64:
+		n.Decimal, err = ParseDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("cannot scan %T into NullDecimal", src)
+	}
+	if err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// Value implements driver.Valuer
+func (n NullDecimal) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Decimal.String(), nil
+}
+
+// UnmarshalJSON accepts null, a JSON number or a string holding a decimal
+func (n *NullDecimal) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*n = NullDecimal{}
+		return nil
+	}
+	s := string(bytes.Trim(data, `"`))
+	d, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*n = NullDecimal{Decimal: d, Valid: true}
+	return nil
+}
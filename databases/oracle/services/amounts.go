@@ -0,0 +1,53 @@
+package services
+
+import "oracle-demo/models"
+
+// numberFormat is the formatting rule of one numeric column in an output.
+// places < 0 writes the exact value; fixed pads to places decimals,
+// otherwise trailing zeros are dropped. Rounding is half away from zero.
+type numberFormat struct {
+	places int
+	fixed  bool
+}
+
+func (f numberFormat) format(n models.NullDecimal) string {
+	switch {
+	case !n.Valid:
+		return ""
+	case f.places < 0:
+		return n.Decimal.String()
+	case f.fixed:
+		return n.Decimal.StringFixed(f.places)
+	default:
+		return n.Decimal.StringMax(f.places)
+	}
+}
+
+// outputNumberFormats holds the numeric column rules of one output format
+type outputNumberFormats struct {
+	amount     numberFormat // Sales/FreeTaxSales/ZeroTaxSales/Tax/TotalAmount
+	taxRate    numberFormat
+	quantity   numberFormat
+	unitPrice  numberFormat
+	lineAmount numberFormat // detail Amount
+}
+
+// csvNumberFormat reproduces the former TO_CHAR output: exact values, and
+// TaxRate with two decimals as TO_CHAR(num_attr04,'0.99') did
+var csvNumberFormat = outputNumberFormats{
+	amount:     numberFormat{places: -1},
+	taxRate:    numberFormat{places: 2, fixed: true},
+	quantity:   numberFormat{places: -1},
+	unitPrice:  numberFormat{places: -1},
+	lineAmount: numberFormat{places: -1},
+}
+
+// xmlNumberFormat follows the MIG 4.x types: invoice amounts are whole
+// dollars, TaxRate has up to 4 decimals and detail numbers up to 7
+var xmlNumberFormat = outputNumberFormats{
+	amount:     numberFormat{places: 0, fixed: true},
+	taxRate:    numberFormat{places: 4},
+	quantity:   numberFormat{places: 7},
+	unitPrice:  numberFormat{places: 7},
+	lineAmount: numberFormat{places: 7},
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"oracle-demo/models"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBatchDays bounds the date range of one batch
+const maxBatchDays = 366
+
+// BatchRequest selects the segments and the invoice date range of a batch
+// C0401 generation
+type BatchRequest struct {
+	// Segments lists segment codes; empty or ["all"] means every
+	// configured segment
+	Segments []string `json:"segments"`
+	// From and To are inclusive YYYYMMDD dates; To defaults to From
+	From   string       `json:"from"`
+	To     string       `json:"to"`
+	Format OutputFormat `json:"format"`
+	// Concurrency is the number of segments generated at once, default 1
+	Concurrency int `json:"concurrency"`
+}
+
+// BatchItem is the outcome of one segment/date generation
+type BatchItem struct {
+	SegmentNo           string `json:"segment_no"`
+	InvoiceDate         string `json:"invoice_date"`
+	Succeeded           bool   `json:"succeeded"`
+	InvoiceCount        int    `json:"invoice_count"`
+	TotalRows           int    `json:"total_rows"`
+	InvalidInvoiceCount int    `json:"invalid_invoice_count"`
+	FilePath            string `json:"file_path,omitempty"`
+	Error               string `json:"error,omitempty"`
+	ErrorCode           string `json:"error_code,omitempty"`
+}
+
+// BatchSegmentSummary totals the items of one segment
+type BatchSegmentSummary struct {
+	SegmentNo    string `json:"segment_no"`
+	Succeeded    int    `json:"succeeded"`
+	Failed       int    `json:"failed"`
+	InvoiceCount int    `json:"invoice_count"`
+	TotalRows    int    `json:"total_rows"`
+}
+
+// BatchReport is the consolidated result of a batch generation. Items are
+// ordered by segment, then date.
+type BatchReport struct {
+	Segments     []string              `json:"segments"`
+	From         string                `json:"from"`
+	To           string                `json:"to"`
+	Format       OutputFormat          `json:"format"`
+	Succeeded    int                   `json:"succeeded"`
+	Failed       int                   `json:"failed"`
+	InvoiceCount int                   `json:"invoice_count"`
+	TotalRows    int                   `json:"total_rows"`
+	PerSegment   []BatchSegmentSummary `json:"per_segment"`
+	Items        []BatchItem           `json:"items"`
+	StartedAt    time.Time             `json:"started_at"`
+	FinishedAt   time.Time             `json:"finished_at"`
+}
+
+// batchReserver claims a segment/date before it is generated so that
+// batches and single jobs never write the same output at once. The
+// returned func releases the claim.
+type batchReserver func(segmentNo, invoiceDate string) (func(), error)
+
+// ResolveBatch validates req and expands it into the segment list and the
+// dates to generate
+func (s *InvoiceService) ResolveBatch(req BatchRequest) ([]string, []string, error) {
+	configured := s.source.Segments()
+	var segments []string
+	if len(req.Segments) == 0 || (len(req.Segments) == 1 && strings.EqualFold(req.Segments[0], "all")) {
+		segments = configured
+	} else {
+		for _, segment := range req.Segments {
+			segment = strings.ToUpper(strings.TrimSpace(segment))
+			if !slices.Contains(configured, segment) {
+				return nil, nil, &models.UnknownSegmentError{Segment: segment}
+			}
+			if !slices.Contains(segments, segment) {
+				segments = append(segments, segment)
+			}
+		}
+	}
+	if len(segments) == 0 {
+		return nil, nil, validationError("NO_SEGMENTS", "no segments configured")
+	}
+
+	to := req.To
+	if to == "" {
+		to = req.From
+	}
+	from, err := time.Parse("20060102", req.From)
+	if err != nil {
+		return nil, nil, validationError("INVALID_DATE", "from must be YYYYMMDD")
+	}
+	until, err := time.Parse("20060102", to)
+	if err != nil {
+		return nil, nil, validationError("INVALID_DATE", "to must be YYYYMMDD")
+	}
+	if until.Before(from) {
+		return nil, nil, validationError("INVALID_DATE_RANGE", "to is before from")
+	}
+	if days := int(until.Sub(from).Hours()/24) + 1; days > maxBatchDays {
+		return nil, nil, validationError("INVALID_DATE_RANGE", "date range spans %d days, at most %d allowed", days, maxBatchDays)
+	}
+
+	var dates []string
+	for d := from; !d.After(until); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format("20060102"))
+	}
+	return segments, dates, nil
+}
+
+// RunBatchC0401 generates C0401 outputs for every segment and date of req.
+// Up to req.Concurrency segments run at once; each segment works through
+// its dates in order on a single session. Failed items do not stop the
+// batch; a canceled ctx skips the remaining dates.
+func (s *InvoiceService) RunBatchC0401(ctx context.Context, req BatchRequest) (*BatchReport, error) {
+	return s.runBatchC0401(ctx, req, nil)
+}
+
+func (s *InvoiceService) runBatchC0401(ctx context.Context, req BatchRequest, reserve batchReserver) (*BatchReport, error) {
+	segments, dates, err := s.ResolveBatch(req)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := req.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	report := &BatchReport{
+		Segments:  segments,
+		From:      dates[0],
+		To:        dates[len(dates)-1],
+		Format:    req.Format,
+		StartedAt: time.Now(),
+	}
+
+	perSegment := make([][]BatchItem, len(segments))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, segment := range segments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			perSegment[i] = s.runBatchSegment(ctx, segment, dates, req.Format, reserve)
+		}()
+	}
+	wg.Wait()
+
+	for i, items := range perSegment {
+		summary := BatchSegmentSummary{SegmentNo: segments[i]}
+		for _, item := range items {
+			if item.Succeeded {
+				summary.Succeeded++
+			} else {
+				summary.Failed++
+			}
+			summary.InvoiceCount += item.InvoiceCount
+			summary.TotalRows += item.TotalRows
+		}
+		report.Succeeded += summary.Succeeded
+		report.Failed += summary.Failed
+		report.InvoiceCount += summary.InvoiceCount
+		report.TotalRows += summary.TotalRows
+		report.PerSegment = append(report.PerSegment, summary)
+		report.Items = append(report.Items, items...)
+	}
+	report.FinishedAt = time.Now()
+
+	return report, nil
+}
+
+// runBatchSegment generates the dates of one segment on a single session
+func (s *InvoiceService) runBatchSegment(ctx context.Context, segmentNo string, dates []string, format OutputFormat, reserve batchReserver) []BatchItem {
+	session := &lazySession{source: s.source, segmentNo: segmentNo}
+	defer session.Close()
+
+	items := make([]BatchItem, 0, len(dates))
+	for _, date := range dates {
+		item := BatchItem{SegmentNo: segmentNo, InvoiceDate: date}
+		if err := ctx.Err(); err != nil {
+			item.Error = err.Error()
+			item.ErrorCode = "CANCELED"
+			items = append(items, item)
+			continue
+		}
+
+		release := func() {}
+		if reserve != nil {
+			var err error
+			if release, err = reserve(segmentNo, date); err != nil {
+				item.Error = err.Error()
+				item.ErrorCode = AsError(err).Code
+				items = append(items, item)
+				continue
+			}
+		}
+
+		result, err := s.genC0401(session, segmentNo, date, format)
+		release()
+
+		if result != nil {
+			item.InvoiceCount = result.InvoiceCount
+			item.TotalRows = result.TotalRows
+			item.FilePath = result.FilePath
+			if result.Validation != nil {
+				item.InvalidInvoiceCount = result.Validation.InvalidInvoiceCount
+			}
+		}
+		if err != nil {
+			item.Error = err.Error()
+			item.ErrorCode = AsError(err).Code
+		} else {
+			item.Succeeded = true
+		}
+		items = append(items, item)
+	}
+	return items
+}
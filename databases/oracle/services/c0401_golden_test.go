@@ -0,0 +1,145 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites the golden files: go test ./services -run Golden -update
+var update = flag.Bool("update", false, "rewrite the golden files under testdata/golden")
+
+// fixtureDir holds <segment>/<YYYYMMDD>.json fixtures shared by the tests
+const fixtureDir = "testdata/fixtures"
+
+func newFixtureService(t testing.TB) *InvoiceService {
+	t.Helper()
+	source, err := NewFixtureInvoiceSource(fixtureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An empty day of a known segment, as PrepareC0401 returns no rows
+	source.Add("LP", "20250702", nil)
+	return NewInvoiceService(t.TempDir(), source)
+}
+
+// assertGolden compares got with testdata/golden/name, rewriting the file
+// under -update
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file\n--- got\n%s\n--- want\n%s", name, got, want)
+	}
+}
+
+// readCSV parses a generated CSV; the Finish marker has a single field
+func readCSV(t *testing.T, data []byte) [][]string {
+	t.Helper()
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+	return records
+}
+
+func TestGenC0401CSVGolden(t *testing.T) {
+	tests := []struct {
+		name         string
+		invoiceDate  string
+		invoiceCount int
+		totalRows    int
+	}{
+		// NULL columns of detail lines and headers, a multi-line invoice, a
+		// quoted remark and numbers given as strings
+		{"invoices", "20250701", 3, 4},
+		// header and Finish marker only
+		{"empty", "20250702", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFixtureService(t)
+
+			result, err := s.GenC0401("LP", tt.invoiceDate, FormatCSV)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.InvoiceCount != tt.invoiceCount {
+				t.Errorf("InvoiceCount = %d, want %d", result.InvoiceCount, tt.invoiceCount)
+			}
+			if result.TotalRows != tt.totalRows {
+				t.Errorf("TotalRows = %d, want %d", result.TotalRows, tt.totalRows)
+			}
+			if !result.Validation.Valid() {
+				t.Errorf("validation failed: %+v", result.Validation)
+			}
+
+			got, err := os.ReadFile(result.FilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, "C0401-"+tt.invoiceDate+"-LP.csv", got)
+
+			records := readCSV(t, got)
+			if n := len(records); n != tt.totalRows+2 {
+				t.Fatalf("got %d records, want header + %d rows + Finish", n, tt.totalRows)
+			}
+			if last := records[len(records)-1]; len(last) != 1 || last[0] != "Finish" {
+				t.Errorf("last record = %q, want the Finish marker", last)
+			}
+		})
+	}
+}
+
+func TestGenC0401CSVNullColumns(t *testing.T) {
+	s := newFixtureService(t)
+
+	result, err := s.GenC0401("LP", "20250701", FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(result.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := readCSV(t, data)
+
+	column := make(map[string]int, len(c0401CSVHeader))
+	for i, name := range c0401CSVHeader {
+		column[name] = i
+	}
+	// The second line of AB12345678 carries no header columns
+	line2 := records[2]
+	for _, name := range []string{"InvoiceDate", "BuyerIdentifier", "SalesAmount", "TaxRate", "TotalAmount", "RandomNumber"} {
+		if v := line2[column[name]]; v != "" {
+			t.Errorf("NULL %s written as %q, want an empty field", name, v)
+		}
+	}
+	if v := line2[column["Remark"]]; v != `no "sugar"` {
+		t.Errorf("Remark = %q", v)
+	}
+	// NULL amounts of a header line stay empty instead of becoming 0
+	header := records[3]
+	if v := header[column["FreeTaxSalesAmount"]]; v != "" {
+		t.Errorf("NULL FreeTaxSalesAmount written as %q", v)
+	}
+	if v := header[column["TaxRate"]]; v != "0.05" {
+		t.Errorf("TaxRate = %q, want 0.05", v)
+	}
+}
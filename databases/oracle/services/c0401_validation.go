@@ -0,0 +1,292 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"oracle-demo/models"
+)
+
+// ErrValidationFailed is returned by the generators in strict mode when at
+// least one invoice breaks the message rules. The report is still returned.
+var ErrValidationFailed = errors.New("invoice validation failed")
+
+var (
+	invoiceNumberPattern   = regexp.MustCompile(`^[A-Z]{2}[0-9]{8}$`)
+	buyerIdentifierPattern = regexp.MustCompile(`^([0-9]{8}|0000000000)$`)
+)
+
+// validTaxTypes are the TaxType codes accepted by C0401
+var validTaxTypes = map[string]bool{"1": true, "2": true, "3": true, "4": true, "9": true}
+
+// ValidationError describes a single rule violation
+type ValidationError struct {
+	InvoiceNumber string `json:"invoice_number"`
+	LineNo        int    `json:"line_no,omitempty"`
+	Field         string `json:"field"`
+	Message       string `json:"message"`
+}
+
+// ValidationReport collects the rule violations of one generation run
+type ValidationReport struct {
+	InvoiceCount        int               `json:"invoice_count"`
+	InvalidInvoiceCount int               `json:"invalid_invoice_count"`
+	Errors              []ValidationError `json:"errors"`
+}
+
+// Valid reports whether no violations were found
+func (r *ValidationReport) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// validationCollector gathers the violations of one generation run
+type validationCollector struct {
+	report  ValidationReport
+	invalid map[string]bool
+}
+
+func newValidationCollector() validationCollector {
+	return validationCollector{
+		report:  ValidationReport{Errors: []ValidationError{}},
+		invalid: make(map[string]bool),
+	}
+}
+
+func (v *validationCollector) addError(invoiceNumber string, lineNo int, field, format string, args ...any) {
+	v.invalid[invoiceNumber] = true
+	v.report.Errors = append(v.report.Errors, ValidationError{
+		InvoiceNumber: invoiceNumber,
+		LineNo:        lineNo,
+		Field:         field,
+		Message:       fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validationCollector) finish() *ValidationReport {
+	v.report.InvalidInvoiceCount = len(v.invalid)
+	return &v.report
+}
+
+// c0401Validator checks streamed invoice rows against the C0401 rules.
+// Rows are expected in InvoiceNumber, LineNo order, with header columns
+// populated on line 1; only the current invoice is buffered.
+type c0401Validator struct {
+	validationCollector
+	current []InvoiceRow
+}
+
+func newC0401Validator() *c0401Validator {
+	return &c0401Validator{validationCollector: newValidationCollector()}
+}
+
+// Add buffers a row, validating the previous invoice once a new
+// InvoiceNumber starts
+func (v *c0401Validator) Add(row InvoiceRow) {
+	if len(v.current) > 0 && v.current[0].InvoiceNumber != row.InvoiceNumber {
+		v.validateInvoice(v.current)
+		v.current = v.current[:0]
+	}
+	v.current = append(v.current, row)
+}
+
+// Finish validates the last buffered invoice and returns the report
+func (v *c0401Validator) Finish() *ValidationReport {
+	if len(v.current) > 0 {
+		v.validateInvoice(v.current)
+		v.current = nil
+	}
+	return v.finish()
+}
+
+// validateInvoice checks the header, amounts and detail lines of one invoice
+func (v *c0401Validator) validateInvoice(rows []InvoiceRow) {
+	v.report.InvoiceCount++
+	invoiceNumber := rows[0].InvoiceNumber.String
+
+	if !invoiceNumberPattern.MatchString(invoiceNumber) {
+		v.addError(invoiceNumber, 0, "InvoiceNumber", "must be 2 uppercase letters followed by 8 digits")
+	}
+
+	header := rows[0]
+	if header.LineNo != 1 {
+		v.addError(invoiceNumber, 0, "LineNo", "invoice has no line 1 carrying the header")
+	} else {
+		v.validateHeader(invoiceNumber, header)
+	}
+
+	for _, row := range rows {
+		v.validateDetail(invoiceNumber, row)
+	}
+
+	if header.LineNo == 1 {
+		v.validateTotals(invoiceNumber, header, rows)
+	}
+}
+
+func (v *c0401Validator) validateHeader(invoiceNumber string, row InvoiceRow) {
+	required := []struct {
+		field string
+		value sql.NullString
+	}{
+		{"InvoiceDate", row.InvoiceDate},
+		{"InvoiceTime", row.InvoiceTime},
+		{"BuyerIdentifier", row.BuyerIdentifier},
+		{"BuyerName", row.BuyerName},
+		{"TaxType", row.TaxType},
+		{"PrintMark", row.PrintMark},
+		{"RandomNumber", row.RandomNumber},
+	}
+	for _, r := range required {
+		if !r.value.Valid || r.value.String == "" {
+			v.addError(invoiceNumber, 0, r.field, "is required")
+		}
+	}
+	for _, r := range []struct {
+		field string
+		value models.NullDecimal
+	}{
+		{"SalesAmount", row.SalesAmount},
+		{"TaxRate", row.TaxRate},
+		{"TaxAmount", row.TaxAmount},
+		{"TotalAmount", row.TotalAmount},
+	} {
+		if !r.value.Valid {
+			v.addError(invoiceNumber, 0, r.field, "is required")
+		}
+	}
+
+	if row.InvoiceDate.Valid && row.InvoiceDate.String != "" {
+		if _, err := time.Parse("20060102", row.InvoiceDate.String); err != nil {
+			v.addError(invoiceNumber, 0, "InvoiceDate", "must be a valid YYYYMMDD date")
+		}
+	}
+
+	if row.TaxType.Valid && row.TaxType.String != "" && !validTaxTypes[row.TaxType.String] {
+		v.addError(invoiceNumber, 0, "TaxType", "must be one of 1, 2, 3, 4, 9")
+	}
+
+	if row.BuyerIdentifier.Valid && row.BuyerIdentifier.String != "" &&
+		!buyerIdentifierPattern.MatchString(row.BuyerIdentifier.String) {
+		v.addError(invoiceNumber, 0, "BuyerIdentifier", "must be 8 digits or 0000000000")
+	}
+
+	v.validateAmounts(invoiceNumber, row)
+	v.validateDelivery(invoiceNumber, row)
+}
+
+// roundingTolerance is the difference allowed between an amount and the
+// product it was rounded from (TaxAmount, detail Amount)
+var roundingTolerance = models.NewDecimal(1)
+
+// validateAmounts checks TaxAmount against SalesAmount×TaxRate and
+// TotalAmount against the sum of its components. NULL amounts count as zero
+// and are reported by the required-field checks.
+func (v *c0401Validator) validateAmounts(invoiceNumber string, row InvoiceRow) {
+	sales := row.SalesAmount.Decimal
+	tax := row.TaxAmount.Decimal
+
+	if row.SalesAmount.Valid && row.TaxRate.Valid && row.TaxAmount.Valid {
+		expected := sales.Mul(row.TaxRate.Decimal)
+		if tax.Sub(expected).Abs().Cmp(roundingTolerance) >= 0 {
+			v.addError(invoiceNumber, 0, "TaxAmount", "%s does not match SalesAmount×TaxRate (%s×%s=%s)",
+				tax, sales, row.TaxRate.Decimal, expected)
+		}
+	}
+
+	if row.TotalAmount.Valid {
+		sum := sales.Add(row.FreeTaxSalesAmount.Decimal).Add(row.ZeroTaxSalesAmount.Decimal).Add(tax)
+		if !row.TotalAmount.Decimal.Equal(sum) {
+			v.addError(invoiceNumber, 0, "TotalAmount", "%s does not equal SalesAmount+FreeTaxSalesAmount+ZeroTaxSalesAmount+TaxAmount (%s)",
+				row.TotalAmount.Decimal, sum)
+		}
+	}
+}
+
+// validateTotals recomputes the invoice total from the detail lines and
+// compares it with the header sales amounts. Detail amounts are either all
+// tax-exclusive (matching Sales+FreeTaxSales+ZeroTaxSales) or all
+// tax-inclusive (matching TotalAmount).
+func (v *c0401Validator) validateTotals(invoiceNumber string, header InvoiceRow, rows []InvoiceRow) {
+	var lines models.Decimal
+	for _, row := range rows {
+		if !row.Amount.Valid {
+			return // reported as required
+		}
+		lines = lines.Add(row.Amount.Decimal)
+	}
+
+	sales := header.SalesAmount.Decimal.Add(header.FreeTaxSalesAmount.Decimal).Add(header.ZeroTaxSalesAmount.Decimal)
+	if lines.Equal(sales) || (header.TotalAmount.Valid && lines.Equal(header.TotalAmount.Decimal)) {
+		return
+	}
+	v.addError(invoiceNumber, 0, "Amount", "detail lines sum to %s, expected %s (sales) or %s (total)",
+		lines, sales, header.TotalAmount.Decimal)
+}
+
+// validateDelivery checks the PrintMark / carrier / NPOBAN exclusivity rules
+func (v *c0401Validator) validateDelivery(invoiceNumber string, row InvoiceRow) {
+	printMark := row.PrintMark.String
+	hasCarrier := row.CarrierType.Valid && row.CarrierType.String != ""
+	hasNPOBAN := row.NPOBAN.Valid && row.NPOBAN.String != ""
+
+	switch printMark {
+	case "Y":
+		if hasCarrier {
+			v.addError(invoiceNumber, 0, "CarrierType", "must be empty when PrintMark is Y")
+		}
+		if hasNPOBAN {
+			v.addError(invoiceNumber, 0, "NPOBAN", "must be empty when PrintMark is Y")
+		}
+	case "N":
+		if !hasCarrier && !hasNPOBAN {
+			v.addError(invoiceNumber, 0, "PrintMark", "N requires a carrier or an NPOBAN")
+		}
+	case "":
+		// reported as required
+	default:
+		v.addError(invoiceNumber, 0, "PrintMark", "must be Y or N")
+	}
+
+	if hasCarrier {
+		if !row.CarrierID1.Valid || row.CarrierID1.String == "" {
+			v.addError(invoiceNumber, 0, "CarrierId1", "is required when CarrierType is set")
+		}
+		if !row.CarrierID2.Valid || row.CarrierID2.String == "" {
+			v.addError(invoiceNumber, 0, "CarrierId2", "is required when CarrierType is set")
+		}
+	}
+
+	if hasNPOBAN && row.BuyerIdentifier.String != "0000000000" {
+		v.addError(invoiceNumber, 0, "NPOBAN", "donation is only allowed for buyers without a business identifier")
+	}
+}
+
+// validateDetail checks the required columns of a detail line
+func (v *c0401Validator) validateDetail(invoiceNumber string, row InvoiceRow) {
+	if !row.Description.Valid || row.Description.String == "" {
+		v.addError(invoiceNumber, row.LineNo, "Description", "is required")
+	}
+	for _, d := range []struct {
+		field string
+		value models.NullDecimal
+	}{
+		{"Quantity", row.Quantity},
+		{"UnitPrice", row.UnitPrice},
+		{"Amount", row.Amount},
+	} {
+		if !d.value.Valid {
+			v.addError(invoiceNumber, row.LineNo, d.field, "is required")
+		}
+	}
+
+	if row.Quantity.Valid && row.UnitPrice.Valid && row.Amount.Valid {
+		expected := row.Quantity.Decimal.Mul(row.UnitPrice.Decimal)
+		if row.Amount.Decimal.Sub(expected).Abs().Cmp(roundingTolerance) >= 0 {
+			v.addError(invoiceNumber, row.LineNo, "Amount", "%s does not equal Quantity×UnitPrice (%s)",
+				row.Amount.Decimal, expected)
+		}
+	}
+}
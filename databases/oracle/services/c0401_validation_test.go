@@ -0,0 +1,232 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"oracle-demo/models"
+	"slices"
+	"testing"
+)
+
+func validString(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+
+func validDecimal(t *testing.T, s string) models.NullDecimal {
+	t.Helper()
+	d, err := models.ParseDecimal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.NullDecimal{Decimal: d, Valid: true}
+}
+
+// validInvoice returns a one-line printed B2B invoice that passes every rule
+func validInvoice(t *testing.T) []InvoiceRow {
+	return []InvoiceRow{{
+		InvoiceNumber:      validString("AB12345678"),
+		InvoiceDate:        validString("20250701"),
+		InvoiceTime:        validString("09:15:00"),
+		BuyerIdentifier:    validString("12345678"),
+		BuyerName:          validString("Example Trading Co."),
+		SalesAmount:        validDecimal(t, "100"),
+		FreeTaxSalesAmount: validDecimal(t, "0"),
+		ZeroTaxSalesAmount: validDecimal(t, "0"),
+		TaxType:            validString("1"),
+		TaxRate:            validDecimal(t, "0.05"),
+		TaxAmount:          validDecimal(t, "5"),
+		TotalAmount:        validDecimal(t, "105"),
+		PrintMark:          validString("Y"),
+		RandomNumber:       validString("0427"),
+		LineNo:             1,
+		Description:        validString("Office paper"),
+		Quantity:           validDecimal(t, "1"),
+		UnitPrice:          validDecimal(t, "100"),
+		Amount:             validDecimal(t, "100"),
+		DetailTaxType:      validString("1"),
+	}}
+}
+
+func TestC0401Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, rows []InvoiceRow) []InvoiceRow
+		// field and line of the single expected error; empty for none
+		field  string
+		lineNo int
+	}{
+		{"valid", func(t *testing.T, rows []InvoiceRow) []InvoiceRow { return rows }, "", 0},
+
+		{"invoice number lowercase", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].InvoiceNumber = validString("ab12345678")
+			return rows
+		}, "InvoiceNumber", 0},
+		{"invoice number too short", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].InvoiceNumber = validString("AB1234567")
+			return rows
+		}, "InvoiceNumber", 0},
+
+		{"unknown tax type", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].TaxType = validString("5")
+			return rows
+		}, "TaxType", 0},
+		{"tax type 9", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].TaxType = validString("9")
+			return rows
+		}, "", 0},
+
+		{"tax off by less than 1", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].TaxAmount = validDecimal(t, "5.99")
+			rows[0].TotalAmount = validDecimal(t, "105.99")
+			return rows
+		}, "", 0},
+		{"tax off by 1", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].TaxAmount = validDecimal(t, "6")
+			rows[0].TotalAmount = validDecimal(t, "106")
+			return rows
+		}, "TaxAmount", 0},
+
+		{"total is not the sum", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].TotalAmount = validDecimal(t, "106")
+			return rows
+		}, "TotalAmount", 0},
+		{"total includes free tax sales", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].SalesAmount = validDecimal(t, "60")
+			rows[0].FreeTaxSalesAmount = validDecimal(t, "40")
+			rows[0].TaxAmount = validDecimal(t, "3")
+			rows[0].TotalAmount = validDecimal(t, "103")
+			return rows
+		}, "", 0},
+
+		{"line amount is not quantity times price", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].Quantity = validDecimal(t, "2")
+			return rows
+		}, "Amount", 1},
+		{"lines do not sum to the sales amount", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			second := rows[0]
+			second.LineNo = 2
+			second.Description = validString("Staples")
+			second.UnitPrice = validDecimal(t, "10")
+			second.Amount = validDecimal(t, "10")
+			return append(rows, second)
+		}, "Amount", 0},
+		{"tax-inclusive lines", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].UnitPrice = validDecimal(t, "105")
+			rows[0].Amount = validDecimal(t, "105")
+			return rows
+		}, "", 0},
+		{"detail line without description", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].Description = sql.NullString{}
+			return rows
+		}, "Description", 1},
+		{"no line 1", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].LineNo = 2
+			return rows
+		}, "LineNo", 0},
+
+		{"printed with a carrier", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].CarrierType = validString("3J0002")
+			rows[0].CarrierID1 = validString("/ABC+123")
+			rows[0].CarrierID2 = validString("/ABC+123")
+			return rows
+		}, "CarrierType", 0},
+		{"printed and donated", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].BuyerIdentifier = validString("0000000000")
+			rows[0].NPOBAN = validString("919")
+			return rows
+		}, "NPOBAN", 0},
+		{"not printed without carrier or NPOBAN", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].PrintMark = validString("N")
+			return rows
+		}, "PrintMark", 0},
+		{"unknown print mark", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].PrintMark = validString("X")
+			return rows
+		}, "PrintMark", 0},
+		{"carrier without CarrierId2", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].PrintMark = validString("N")
+			rows[0].CarrierType = validString("3J0002")
+			rows[0].CarrierID1 = validString("/ABC+123")
+			return rows
+		}, "CarrierId2", 0},
+		{"donation to a business buyer", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].PrintMark = validString("N")
+			rows[0].NPOBAN = validString("919")
+			return rows
+		}, "NPOBAN", 0},
+		{"donation by a consumer", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].PrintMark = validString("N")
+			rows[0].BuyerIdentifier = validString("0000000000")
+			rows[0].NPOBAN = validString("919")
+			return rows
+		}, "", 0},
+
+		{"bad buyer identifier", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].BuyerIdentifier = validString("1234567")
+			return rows
+		}, "BuyerIdentifier", 0},
+		{"missing random number", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].RandomNumber = sql.NullString{}
+			return rows
+		}, "RandomNumber", 0},
+		{"bad invoice date", func(t *testing.T, rows []InvoiceRow) []InvoiceRow {
+			rows[0].InvoiceDate = validString("20250231")
+			return rows
+		}, "InvoiceDate", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := tt.change(t, validInvoice(t))
+
+			s := NewInvoiceService(t.TempDir(), NewMemoryInvoiceSource())
+			s.SetStrictValidation(true)
+			result, err := s.writeRows(func(yield func(InvoiceRow, error) bool) {
+				for _, row := range rows {
+					if !yield(row, nil) {
+						return
+					}
+				}
+			}, discardWriter{}, &Manifest{})
+
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("err = %v, errors %+v", err, result.Validation.Errors)
+				}
+				return
+			}
+			if !errors.Is(err, ErrValidationFailed) {
+				t.Fatalf("err = %v, want ErrValidationFailed", err)
+			}
+			report := result.Validation
+			if report.InvalidInvoiceCount != 1 {
+				t.Errorf("InvalidInvoiceCount = %d, want 1", report.InvalidInvoiceCount)
+			}
+			if len(report.Errors) != 1 {
+				t.Fatalf("got %d errors, want 1: %+v", len(report.Errors), report.Errors)
+			}
+			got := report.Errors[0]
+			if got.Field != tt.field || got.LineNo != tt.lineNo {
+				t.Errorf("error on %s line %d (%s), want %s line %d", got.Field, got.LineNo, got.Message, tt.field, tt.lineNo)
+			}
+			if got.InvoiceNumber != rows[0].InvoiceNumber.String {
+				t.Errorf("InvoiceNumber = %q", got.InvoiceNumber)
+			}
+		})
+	}
+}
+
+// Only the invalid invoice of a run is counted as invalid
+func TestC0401ValidationCountsInvoices(t *testing.T) {
+	valid := validInvoice(t)
+	invalid := validInvoice(t)
+	invalid[0].InvoiceNumber = validString("AB12345679")
+	invalid[0].TaxType = validString("5")
+
+	v := newC0401Validator()
+	for _, row := range slices.Concat(valid, invalid) {
+		v.Add(row)
+	}
+	report := v.Finish()
+	if report.InvoiceCount != 2 || report.InvalidInvoiceCount != 1 {
+		t.Errorf("InvoiceCount = %d, InvalidInvoiceCount = %d, want 2 and 1", report.InvoiceCount, report.InvalidInvoiceCount)
+	}
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"oracle-demo/models"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// C0401 MIG 4.x namespace used by the Turnkey gateway
+const c0401Namespace = "urn:GEINV:eInvoiceMessage:C0401:4.0"
+
+// defaultInvoiceType is the general tax invoice
+const defaultInvoiceType = "07"
+
+// SegmentIssuer is what C0401 XML needs about the issuing company of a
+// segment. MRIF004 carries neither value, so both come from the segment
+// configuration.
+type SegmentIssuer struct {
+	Seller models.SellerConfig
+	// InvoiceType is 07 when empty
+	InvoiceType string
+}
+
+// C0401Invoice is the root element of one MIG C0401 document
+type C0401Invoice struct {
+	XMLName xml.Name     `xml:"Invoice"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Main    C0401Main    `xml:"Main"`
+	Details C0401Details `xml:"Details"`
+	Amount  C0401Amount  `xml:"Amount"`
+}
+
+// C0401Main holds the invoice header, in MIG element order
+type C0401Main struct {
+	InvoiceNumber string      `xml:"InvoiceNumber"`
+	InvoiceDate   string      `xml:"InvoiceDate"`
+	InvoiceTime   string      `xml:"InvoiceTime"`
+	Seller        C0401Seller `xml:"Seller"`
+	Buyer         C0401Buyer  `xml:"Buyer"`
+	MainRemark    string      `xml:"MainRemark,omitempty"`
+	InvoiceType   string      `xml:"InvoiceType"`
+	DonateMark    string      `xml:"DonateMark"`
+	CarrierType   string      `xml:"CarrierType,omitempty"`
+	CarrierID1    string      `xml:"CarrierId1,omitempty"`
+	CarrierID2    string      `xml:"CarrierId2,omitempty"`
+	PrintMark     string      `xml:"PrintMark"`
+	NPOBAN        string      `xml:"NPOBAN,omitempty"`
+	RandomNumber  string      `xml:"RandomNumber"`
+}
+
+// C0401Seller holds the seller role description
+type C0401Seller struct {
+	Identifier string `xml:"Identifier"`
+	Name       string `xml:"Name"`
+	Address    string `xml:"Address"`
+}
+
+// C0401Buyer holds the buyer role description
+type C0401Buyer struct {
+	Identifier      string `xml:"Identifier"`
+	Name            string `xml:"Name"`
+	Address         string `xml:"Address,omitempty"`
+	TelephoneNumber string `xml:"TelephoneNumber,omitempty"`
+	EmailAddress    string `xml:"EmailAddress,omitempty"`
+}
+
+// C0401Details holds the product items of an invoice
+type C0401Details struct {
+	ProductItems []C0401ProductItem `xml:"ProductItem"`
+}
+
+// C0401ProductItem is a single detail line
+type C0401ProductItem struct {
+	Description    string `xml:"Description"`
+	Quantity       string `xml:"Quantity"`
+	UnitPrice      string `xml:"UnitPrice"`
+	TaxType        string `xml:"TaxType,omitempty"`
+	Amount         string `xml:"Amount"`
+	SequenceNumber string `xml:"SequenceNumber"`
+	Remark         string `xml:"Remark,omitempty"`
+}
+
+// C0401Amount holds the invoice totals
+type C0401Amount struct {
+	SalesAmount        string `xml:"SalesAmount"`
+	FreeTaxSalesAmount string `xml:"FreeTaxSalesAmount"`
+	ZeroTaxSalesAmount string `xml:"ZeroTaxSalesAmount"`
+	TaxType            string `xml:"TaxType"`
+	TaxRate            string `xml:"TaxRate"`
+	TaxAmount          string `xml:"TaxAmount"`
+	TotalAmount        string `xml:"TotalAmount"`
+}
+
+// xmlWriter groups streamed rows by InvoiceNumber into MIG documents and
+// writes one XML file per invoice. Only the invoice currently being
+// assembled is held in memory.
+type xmlWriter struct {
+	s       *InvoiceService
+	issuer  SegmentIssuer
+	tmpDir  string
+	dirPath string
+	current *C0401Invoice
+	files   []ManifestFile
+}
+
+// newXMLWriter prepares the xmlDirPath directory (written under a .tmp
+// name until committed). Every document names issuer as its seller.
+func (s *InvoiceService) newXMLWriter(xmlDirPath string, issuer SegmentIssuer) (*xmlWriter, error) {
+	tmpDir := xmlDirPath + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, fmt.Errorf("removing stale directory: %w", err)
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+
+	return &xmlWriter{
+		s:       s,
+		issuer:  issuer,
+		tmpDir:  tmpDir,
+		dirPath: xmlDirPath,
+	}, nil
+}
+
+// WriteRow adds a row to the current invoice, flushing the previous one when
+// the InvoiceNumber changes. Header and amount columns are only populated
+// on line 1.
+func (w *xmlWriter) WriteRow(row InvoiceRow) error {
+	s := w.s
+	invoiceNumber := s.nullStringToString(row.InvoiceNumber)
+	if invoiceNumber == "" {
+		return fmt.Errorf("invoice without InvoiceNumber")
+	}
+
+	if w.current == nil || w.current.Main.InvoiceNumber != invoiceNumber {
+		if err := w.flush(); err != nil {
+			return err
+		}
+		w.current = &C0401Invoice{
+			Xmlns: c0401Namespace,
+			Main:  C0401Main{InvoiceNumber: invoiceNumber},
+		}
+	}
+	invoice := w.current
+
+	if row.LineNo == 1 {
+		npoban := s.nullStringToString(row.NPOBAN)
+		// An invoice is donated exactly when it names a charity
+		donateMark := "0"
+		if npoban != "" {
+			donateMark = "1"
+		}
+		invoice.Main = C0401Main{
+			InvoiceNumber: invoiceNumber,
+			InvoiceDate:   s.nullStringToString(row.InvoiceDate),
+			InvoiceTime:   s.nullStringToString(row.InvoiceTime),
+			Seller:        C0401Seller(w.issuer.Seller),
+			Buyer: C0401Buyer{
+				Identifier:      s.nullStringToString(row.BuyerIdentifier),
+				Name:            s.nullStringToString(row.BuyerName),
+				Address:         s.nullStringToString(row.BuyerAddress),
+				TelephoneNumber: s.nullStringToString(row.BuyerTelephoneNumber),
+				EmailAddress:    s.nullStringToString(row.BuyerEmailAddress),
+			},
+			MainRemark:   s.nullStringToString(row.MainRemark),
+			InvoiceType:  w.issuer.InvoiceType,
+			DonateMark:   donateMark,
+			CarrierType:  s.nullStringToString(row.CarrierType),
+			CarrierID1:   s.nullStringToString(row.CarrierID1),
+			CarrierID2:   s.nullStringToString(row.CarrierID2),
+			PrintMark:    s.nullStringToString(row.PrintMark),
+			NPOBAN:       npoban,
+			RandomNumber: s.nullStringToString(row.RandomNumber),
+		}
+		invoice.Amount = C0401Amount{
+			SalesAmount:        xmlNumberFormat.amount.format(row.SalesAmount),
+			FreeTaxSalesAmount: xmlNumberFormat.amount.format(row.FreeTaxSalesAmount),
+			ZeroTaxSalesAmount: xmlNumberFormat.amount.format(row.ZeroTaxSalesAmount),
+			TaxType:            s.nullStringToString(row.TaxType),
+			TaxRate:            xmlNumberFormat.taxRate.format(row.TaxRate),
+			TaxAmount:          xmlNumberFormat.amount.format(row.TaxAmount),
+			TotalAmount:        xmlNumberFormat.amount.format(row.TotalAmount),
+		}
+	}
+
+	invoice.Details.ProductItems = append(invoice.Details.ProductItems, C0401ProductItem{
+		Description:    s.nullStringToString(row.Description),
+		Quantity:       xmlNumberFormat.quantity.format(row.Quantity),
+		UnitPrice:      xmlNumberFormat.unitPrice.format(row.UnitPrice),
+		TaxType:        s.nullStringToString(row.DetailTaxType),
+		Amount:         xmlNumberFormat.lineAmount.format(row.Amount),
+		SequenceNumber: fmt.Sprintf("%d", row.LineNo),
+		Remark:         s.nullStringToString(row.Remark),
+	})
+	return nil
+}
+
+// flush writes the invoice being assembled, if any
+func (w *xmlWriter) flush() error {
+	if w.current == nil {
+		return nil
+	}
+	invoice := w.current
+	w.current = nil
+	name := invoice.Main.InvoiceNumber + ".xml"
+	checksum, err := writeXMLFile(filepath.Join(w.tmpDir, name), invoice)
+	if err != nil {
+		return fmt.Errorf("writing invoice %s: %w", invoice.Main.InvoiceNumber, err)
+	}
+	w.files = append(w.files, ManifestFile{Name: name, SHA256: checksum})
+	return nil
+}
+
+// Commit writes the last invoice and renames the synced directory into
+// place next to its manifest
+func (w *xmlWriter) Commit(manifest *Manifest) (string, error) {
+	if err := w.flush(); err != nil {
+		w.Abort()
+		return "", err
+	}
+	if err := w.s.commitXMLDir(w.tmpDir, w.dirPath, w.files, manifest); err != nil {
+		w.Abort()
+		return "", err
+	}
+	return w.dirPath, nil
+}
+
+// commitXMLDir syncs tmpDir and renames it to dirPath next to its manifest.
+// The directory checksum is the SHA-256 of its sha256sum-style listing,
+// sorted by file name.
+func (s *InvoiceService) commitXMLDir(tmpDir, dirPath string, files []ManifestFile, manifest *Manifest) error {
+	if err := syncDir(tmpDir); err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	listing := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(listing, "%s  %s\n", f.SHA256, f.Name)
+	}
+	manifest.SHA256 = hex.EncodeToString(listing.Sum(nil))
+	manifest.Files = files
+
+	return s.installOutput(tmpDir, dirPath, manifest)
+}
+
+// Abort removes the temporary directory
+func (w *xmlWriter) Abort() {
+	w.current = nil
+	os.RemoveAll(w.tmpDir)
+}
+
+// writeXMLFile encodes a single MIG document to path, fsyncs it and
+// returns its SHA-256
+func writeXMLFile(path string, doc any) (string, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("creating XML file: %w", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	out := io.MultiWriter(file, h)
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return "", fmt.Errorf("writing XML header: %w", err)
+	}
+
+	encoder := xml.NewEncoder(out)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return "", fmt.Errorf("encoding XML: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("closing XML encoder: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return "", fmt.Errorf("syncing XML file: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), file.Close()
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"iter"
+	"log"
+	"oracle-demo/models"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// CancelVoidRow is one C0501 cancellation or C0701 void. Date, Time and
+// Reason are CancelDate/CancelTime/CancelReason for C0501 and
+// VoidDate/VoidTime/VoidReason for C0701; ReturnTaxDocumentNumber only
+// exists in C0501.
+type CancelVoidRow struct {
+	InvoiceNumber           sql.NullString
+	InvoiceDate             sql.NullString
+	BuyerID                 sql.NullString
+	SellerID                sql.NullString
+	Date                    sql.NullString
+	Time                    sql.NullString
+	Reason                  sql.NullString
+	ReturnTaxDocumentNumber sql.NullString
+	Remark                  sql.NullString
+}
+
+// cancelVoidMessage describes how C0501 and C0701 differ
+type cancelVoidMessage struct {
+	messageType MessageType
+	// prefix of the message-specific columns: Cancel or Void
+	prefix string
+	// returnTaxDocument adds the C0501 ReturnTaxDocumentNumber column
+	returnTaxDocument bool
+	prepare           func(InvoiceSession, time.Time) error
+	rows              func(InvoiceSession) iter.Seq2[CancelVoidRow, error]
+}
+
+var c0501Message = cancelVoidMessage{
+	messageType:       MessageC0501,
+	prefix:            "Cancel",
+	returnTaxDocument: true,
+	prepare:           InvoiceSession.PrepareC0501,
+	rows:              InvoiceSession.C0501Rows,
+}
+
+var c0701Message = cancelVoidMessage{
+	messageType: MessageC0701,
+	prefix:      "Void",
+	prepare:     InvoiceSession.PrepareC0701,
+	rows:        InvoiceSession.C0701Rows,
+}
+
+// GenC0501 generates C0501 cancellation CSV or XML files for the
+// cancellations made on cancelDate (YYYYMMDD)
+func (s *InvoiceService) GenC0501(segmentNo, cancelDate string, format OutputFormat) (*CSVGenerationResult, error) {
+	return s.genCancelVoid(c0501Message, segmentNo, cancelDate, format)
+}
+
+// GenC0701 generates C0701 void CSV or XML files for the voids made on
+// voidDate (YYYYMMDD)
+func (s *InvoiceService) GenC0701(segmentNo, voidDate string, format OutputFormat) (*CSVGenerationResult, error) {
+	return s.genCancelVoid(c0701Message, segmentNo, voidDate, format)
+}
+
+func (s *InvoiceService) genCancelVoid(msg cancelVoidMessage, segmentNo, date string, format OutputFormat) (*CSVGenerationResult, error) {
+	parsedDate, err := time.Parse("20060102", date)
+	if err != nil {
+		return nil, validationError("INVALID_DATE", "%s date must be YYYYMMDD: %q", msg.messageType, date)
+	}
+
+	outputPath := s.outputPath(msg.messageType, segmentNo, date, format)
+	if err := s.checkRegenerate(outputPath); err != nil {
+		return nil, err
+	}
+
+	session, err := s.source.Open(segmentNo)
+	if err != nil {
+		return nil, fmt.Errorf("opening invoice source: %w", err)
+	}
+	defer session.Close()
+
+	if err := msg.prepare(session, parsedDate); err != nil {
+		return nil, fmt.Errorf("preparing %s data: %w", msg.messageType, err)
+	}
+
+	var writer rowWriter[CancelVoidRow]
+	switch format {
+	case FormatXML:
+		writer, err = s.newCancelVoidXMLWriter(msg, outputPath)
+	default:
+		writer, err = newCSVWriter(s, outputPath, msg.csvHeader(), func(row CancelVoidRow) []string {
+			return msg.record(s, row)
+		})
+	}
+	if err != nil {
+		return nil, storageError(fmt.Sprintf("creating %s writer", format), err)
+	}
+
+	return streamRows(s, msg.rows(session), writer, newCancelVoidValidator(msg), &Manifest{
+		MessageType: msg.messageType,
+		SegmentNo:   segmentNo,
+		InvoiceDate: date,
+		Format:      format,
+	}, func(row *CancelVoidRow, result *CSVGenerationResult) {
+		// One row is one invoice
+		result.InvoiceCount++
+		hits := s.applyRareCharFields(row.InvoiceNumber.String, 0, []rareCharField{
+			{msg.prefix + "Reason", &row.Reason},
+			{"Remark", &row.Remark},
+		})
+		if len(hits) > 0 {
+			log.Printf("%s %s contains %d rare characters", msg.messageType, row.InvoiceNumber.String, len(hits))
+			result.RareCharacters = append(result.RareCharacters, hits...)
+		}
+	})
+}
+
+// csvHeader returns the header row of the flat C0501/C0701 CSV
+func (m cancelVoidMessage) csvHeader() []string {
+	header := []string{
+		m.prefix + "InvoiceNumber", "InvoiceDate", "BuyerId", "SellerId",
+		m.prefix + "Date", m.prefix + "Time", m.prefix + "Reason",
+	}
+	if m.returnTaxDocument {
+		header = append(header, "ReturnTaxDocumentNumber")
+	}
+	return append(header, "Remark")
+}
+
+// record converts a row to a CSV record matching csvHeader
+func (m cancelVoidMessage) record(s *InvoiceService, row CancelVoidRow) []string {
+	record := []string{
+		s.nullStringToString(row.InvoiceNumber),
+		s.nullStringToString(row.InvoiceDate),
+		s.nullStringToString(row.BuyerID),
+		s.nullStringToString(row.SellerID),
+		s.nullStringToString(row.Date),
+		s.nullStringToString(row.Time),
+		s.nullStringToString(row.Reason),
+	}
+	if m.returnTaxDocument {
+		record = append(record, s.nullStringToString(row.ReturnTaxDocumentNumber))
+	}
+	return append(record, s.nullStringToString(row.Remark))
+}
+
+var migTimePattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]:[0-5][0-9]$`)
+
+// MIG 4.x length limits of the C0501/C0701 free-text columns
+const (
+	maxReasonLength                  = 20
+	maxReturnTaxDocumentNumberLength = 60
+	maxCancelVoidRemarkLength        = 200
+)
+
+// cancelVoidValidator checks C0501/C0701 rows, one invoice per row
+type cancelVoidValidator struct {
+	validationCollector
+	msg  cancelVoidMessage
+	seen map[string]bool
+}
+
+func newCancelVoidValidator(msg cancelVoidMessage) *cancelVoidValidator {
+	return &cancelVoidValidator{
+		validationCollector: newValidationCollector(),
+		msg:                 msg,
+		seen:                make(map[string]bool),
+	}
+}
+
+// Add validates one row
+func (v *cancelVoidValidator) Add(row CancelVoidRow) {
+	v.report.InvoiceCount++
+	invoiceNumber := row.InvoiceNumber.String
+	prefix := v.msg.prefix
+
+	if !invoiceNumberPattern.MatchString(invoiceNumber) {
+		v.addError(invoiceNumber, 0, prefix+"InvoiceNumber", "must be 2 uppercase letters followed by 8 digits")
+	}
+	if v.seen[invoiceNumber] {
+		v.addError(invoiceNumber, 0, prefix+"InvoiceNumber", "appears more than once")
+	}
+	v.seen[invoiceNumber] = true
+
+	for _, r := range []struct {
+		field string
+		value sql.NullString
+	}{
+		{"InvoiceDate", row.InvoiceDate},
+		{"BuyerId", row.BuyerID},
+		{"SellerId", row.SellerID},
+		{prefix + "Date", row.Date},
+		{prefix + "Time", row.Time},
+		{prefix + "Reason", row.Reason},
+	} {
+		if !r.value.Valid || r.value.String == "" {
+			v.addError(invoiceNumber, 0, r.field, "is required")
+		}
+	}
+
+	invoiceDate, invoiceDateErr := time.Parse("20060102", row.InvoiceDate.String)
+	if row.InvoiceDate.String != "" && invoiceDateErr != nil {
+		v.addError(invoiceNumber, 0, "InvoiceDate", "must be a valid YYYYMMDD date")
+	}
+	date, dateErr := time.Parse("20060102", row.Date.String)
+	if row.Date.String != "" && dateErr != nil {
+		v.addError(invoiceNumber, 0, prefix+"Date", "must be a valid YYYYMMDD date")
+	}
+	if invoiceDateErr == nil && dateErr == nil && date.Before(invoiceDate) {
+		v.addError(invoiceNumber, 0, prefix+"Date", "is before InvoiceDate")
+	}
+	if row.Time.String != "" && !migTimePattern.MatchString(row.Time.String) {
+		v.addError(invoiceNumber, 0, prefix+"Time", "must be HH:MM:SS")
+	}
+
+	if row.BuyerID.String != "" && !buyerIdentifierPattern.MatchString(row.BuyerID.String) {
+		v.addError(invoiceNumber, 0, "BuyerId", "must be 8 digits or 0000000000")
+	}
+	if row.SellerID.String != "" && !models.ValidSellerIdentifier(row.SellerID.String) {
+		v.addError(invoiceNumber, 0, "SellerId", "must be 8 digits")
+	}
+
+	for _, l := range []struct {
+		field string
+		value string
+		max   int
+	}{
+		{prefix + "Reason", row.Reason.String, maxReasonLength},
+		{"ReturnTaxDocumentNumber", row.ReturnTaxDocumentNumber.String, maxReturnTaxDocumentNumberLength},
+		{"Remark", row.Remark.String, maxCancelVoidRemarkLength},
+	} {
+		if n := utf8.RuneCountInString(l.value); n > l.max {
+			v.addError(invoiceNumber, 0, l.field, "is %d characters long, at most %d allowed", n, l.max)
+		}
+	}
+}
+
+// Finish returns the report
+func (v *cancelVoidValidator) Finish() *ValidationReport {
+	return v.finish()
+}
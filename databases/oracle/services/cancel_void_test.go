@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func cancellation(invoiceNumber, sellerID string) CancelVoidRow {
+	return CancelVoidRow{
+		InvoiceNumber: validString(invoiceNumber),
+		InvoiceDate:   validString("20250701"),
+		BuyerID:       validString("0000000000"),
+		SellerID:      validString(sellerID),
+		Date:          validString("20250702"),
+		Time:          validString("10:00:00"),
+		Reason:        validString("Wrong amount"),
+	}
+}
+
+func TestGenC0501(t *testing.T) {
+	source := NewMemoryInvoiceSource()
+	source.AddCancelVoid(MessageC0501, "LP", "20250702", []CancelVoidRow{
+		cancellation("AB12345678", "12345678"),
+		cancellation("AB12345679", "1234567"),
+	})
+	s := NewInvoiceService(t.TempDir(), source)
+
+	result, err := s.GenC0501("LP", "20250702", FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InvoiceCount != 2 || result.TotalRows != 2 {
+		t.Errorf("result = %d invoices, %d rows, want 2 and 2", result.InvoiceCount, result.TotalRows)
+	}
+	report := result.Validation
+	if report.InvalidInvoiceCount != 1 || len(report.Errors) != 1 || report.Errors[0].Field != "SellerId" {
+		t.Errorf("validation = %+v", report)
+	}
+	body, err := os.ReadFile(result.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(body), "\n"); lines != 4 {
+		t.Errorf("CSV has %d lines, want a header, 2 rows and Finish:\n%s", lines, body)
+	}
+
+	// Strict validation refuses the same rows without writing a file
+	s = NewInvoiceService(t.TempDir(), source)
+	s.SetStrictValidation(true)
+	if _, err := s.GenC0501("LP", "20250702", FormatCSV); !errors.Is(err, ErrValidationFailed) {
+		t.Errorf("strict err = %v, want ErrValidationFailed", err)
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// C0501/C0701 MIG 4.x namespaces used by the Turnkey gateway
+const (
+	c0501Namespace = "urn:GEINV:eInvoiceMessage:C0501:4.0"
+	c0701Namespace = "urn:GEINV:eInvoiceMessage:C0701:4.0"
+)
+
+// C0501CancelInvoice is the root element of one MIG C0501 document
+type C0501CancelInvoice struct {
+	XMLName                 xml.Name `xml:"CancelInvoice"`
+	Xmlns                   string   `xml:"xmlns,attr"`
+	CancelInvoiceNumber     string   `xml:"CancelInvoiceNumber"`
+	InvoiceDate             string   `xml:"InvoiceDate"`
+	BuyerID                 string   `xml:"BuyerId"`
+	SellerID                string   `xml:"SellerId"`
+	CancelDate              string   `xml:"CancelDate"`
+	CancelTime              string   `xml:"CancelTime"`
+	CancelReason            string   `xml:"CancelReason"`
+	ReturnTaxDocumentNumber string   `xml:"ReturnTaxDocumentNumber,omitempty"`
+	Remark                  string   `xml:"Remark,omitempty"`
+}
+
+// C0701VoidInvoice is the root element of one MIG C0701 document
+type C0701VoidInvoice struct {
+	XMLName           xml.Name `xml:"VoidInvoice"`
+	Xmlns             string   `xml:"xmlns,attr"`
+	VoidInvoiceNumber string   `xml:"VoidInvoiceNumber"`
+	InvoiceDate       string   `xml:"InvoiceDate"`
+	BuyerID           string   `xml:"BuyerId"`
+	SellerID          string   `xml:"SellerId"`
+	VoidDate          string   `xml:"VoidDate"`
+	VoidTime          string   `xml:"VoidTime"`
+	VoidReason        string   `xml:"VoidReason"`
+	Remark            string   `xml:"Remark,omitempty"`
+}
+
+// document converts a row to the MIG document of the message
+func (m cancelVoidMessage) document(s *InvoiceService, row CancelVoidRow) any {
+	if m.messageType == MessageC0501 {
+		return &C0501CancelInvoice{
+			Xmlns:                   c0501Namespace,
+			CancelInvoiceNumber:     s.nullStringToString(row.InvoiceNumber),
+			InvoiceDate:             s.nullStringToString(row.InvoiceDate),
+			BuyerID:                 s.nullStringToString(row.BuyerID),
+			SellerID:                s.nullStringToString(row.SellerID),
+			CancelDate:              s.nullStringToString(row.Date),
+			CancelTime:              s.nullStringToString(row.Time),
+			CancelReason:            s.nullStringToString(row.Reason),
+			ReturnTaxDocumentNumber: s.nullStringToString(row.ReturnTaxDocumentNumber),
+			Remark:                  s.nullStringToString(row.Remark),
+		}
+	}
+	return &C0701VoidInvoice{
+		Xmlns:             c0701Namespace,
+		VoidInvoiceNumber: s.nullStringToString(row.InvoiceNumber),
+		InvoiceDate:       s.nullStringToString(row.InvoiceDate),
+		BuyerID:           s.nullStringToString(row.BuyerID),
+		SellerID:          s.nullStringToString(row.SellerID),
+		VoidDate:          s.nullStringToString(row.Date),
+		VoidTime:          s.nullStringToString(row.Time),
+		VoidReason:        s.nullStringToString(row.Reason),
+		Remark:            s.nullStringToString(row.Remark),
+	}
+}
+
+// cancelVoidXMLWriter writes one MIG document per C0501/C0701 row
+type cancelVoidXMLWriter struct {
+	s       *InvoiceService
+	msg     cancelVoidMessage
+	tmpDir  string
+	dirPath string
+	files   []ManifestFile
+	seen    map[string]bool
+}
+
+// newCancelVoidXMLWriter prepares the xmlDirPath directory (written under a
+// .tmp name until committed)
+func (s *InvoiceService) newCancelVoidXMLWriter(msg cancelVoidMessage, xmlDirPath string) (*cancelVoidXMLWriter, error) {
+	tmpDir := xmlDirPath + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, fmt.Errorf("removing stale directory: %w", err)
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+
+	return &cancelVoidXMLWriter{
+		s:       s,
+		msg:     msg,
+		tmpDir:  tmpDir,
+		dirPath: xmlDirPath,
+		seen:    make(map[string]bool),
+	}, nil
+}
+
+// WriteRow writes the document of one row to <InvoiceNumber>.xml
+func (w *cancelVoidXMLWriter) WriteRow(row CancelVoidRow) error {
+	invoiceNumber := w.s.nullStringToString(row.InvoiceNumber)
+	if invoiceNumber == "" {
+		return fmt.Errorf("%s without %sInvoiceNumber", w.msg.messageType, w.msg.prefix)
+	}
+	if w.seen[invoiceNumber] {
+		return fmt.Errorf("duplicate %s for invoice %s", w.msg.messageType, invoiceNumber)
+	}
+	w.seen[invoiceNumber] = true
+
+	name := invoiceNumber + ".xml"
+	checksum, err := writeXMLFile(filepath.Join(w.tmpDir, name), w.msg.document(w.s, row))
+	if err != nil {
+		return fmt.Errorf("writing invoice %s: %w", invoiceNumber, err)
+	}
+	w.files = append(w.files, ManifestFile{Name: name, SHA256: checksum})
+	return nil
+}
+
+// Commit renames the synced directory into place next to its manifest
+func (w *cancelVoidXMLWriter) Commit(manifest *Manifest) (string, error) {
+	if err := w.s.commitXMLDir(w.tmpDir, w.dirPath, w.files, manifest); err != nil {
+		w.Abort()
+		return "", err
+	}
+	return w.dirPath, nil
+}
+
+// Abort removes the temporary directory
+func (w *cancelVoidXMLWriter) Abort() {
+	os.RemoveAll(w.tmpDir)
+}
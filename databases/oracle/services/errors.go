@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"oracle-demo/models"
+	"regexp"
+	"strconv"
+	"syscall"
+
+	"github.com/sijms/go-ora/v2/network"
+)
+
+// ErrorKind is the category of an Error
+type ErrorKind string
+
+const (
+	// KindValidation covers bad request parameters and rows failing the
+	// message rules
+	KindValidation ErrorKind = "validation"
+	// KindNotFound covers unknown segments, jobs and files
+	KindNotFound ErrorKind = "not_found"
+	// KindConflict covers work that is already running or already done
+	KindConflict ErrorKind = "conflict"
+	// KindUpstream covers Oracle failures
+	KindUpstream ErrorKind = "upstream_db"
+	// KindConfig covers features this deployment has not configured
+	KindConfig ErrorKind = "config"
+	// KindStorage covers failures writing or reading the output tree
+	KindStorage ErrorKind = "storage"
+	// KindInternal is everything else
+	KindInternal ErrorKind = "internal"
+)
+
+// Error is a classified service error. Code is a stable machine-readable
+// identifier, Status the HTTP status it maps to.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Status  int
+	Message string
+	// OraCode is the ORA-nnnnn number of an upstream error, if known
+	OraCode int
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil && e.Message == "" {
+		return e.Err.Error()
+	}
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// validationError reports a bad parameter
+func validationError(code, format string, args ...any) *Error {
+	return &Error{Kind: KindValidation, Code: code, Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// reportNotConfiguredError reports a message type whose Oracle procedure
+// and query are not configured
+func reportNotConfiguredError(messageType MessageType) *Error {
+	return &Error{Kind: KindConfig, Code: "REPORT_NOT_CONFIGURED", Status: http.StatusNotImplemented,
+		Message: fmt.Sprintf("no %s procedure and query are configured", messageType)}
+}
+
+// storageError wraps a failure of the output tree. A full disk maps to
+// 507 so that it is not retried blindly.
+func storageError(message string, err error) *Error {
+	e := &Error{Kind: KindStorage, Code: "STORAGE_ERROR", Status: http.StatusInternalServerError, Message: message, Err: err}
+	if errors.Is(err, syscall.ENOSPC) {
+		e.Code = "STORAGE_FULL"
+		e.Status = http.StatusInsufficientStorage
+	}
+	return e
+}
+
+// oraCodePattern finds the error number in driver messages that do not
+// carry a *network.OracleError
+var oraCodePattern = regexp.MustCompile(`ORA-(\d{5})`)
+
+// oraErrorCode extracts the ORA-nnnnn number of err, or 0
+func oraErrorCode(err error) int {
+	var oraErr *network.OracleError
+	if errors.As(err, &oraErr) {
+		return oraErr.ErrCode
+	}
+	if m := oraCodePattern.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return code
+	}
+	return 0
+}
+
+// upstreamError wraps an Oracle failure, mapping well-known ORA codes to
+// a more specific code and status
+func upstreamError(message string, err error) *Error {
+	e := &Error{Kind: KindUpstream, Code: "UPSTREAM_DB", Status: http.StatusBadGateway, Message: message, Err: err}
+	if errors.Is(err, context.DeadlineExceeded) {
+		e.Code, e.Status = "DB_TIMEOUT", http.StatusGatewayTimeout
+		return e
+	}
+
+	e.OraCode = oraErrorCode(err)
+	switch code := e.OraCode; {
+	case code == 0:
+	case code == 1017 || code == 28000 || code == 28001:
+		// invalid credentials, locked or expired account
+		e.Code, e.Status = "DB_AUTH_FAILED", http.StatusBadGateway
+	case code == 12514 || code == 12541 || code == 12543 || code == 12545 ||
+		code == 3113 || code == 3114 || code == 3135 || code == 1033 || code == 1034:
+		// no listener, unknown service, lost connection, instance down
+		e.Code, e.Status = "DB_UNAVAILABLE", http.StatusServiceUnavailable
+	case code == 12170 || code == 12535 || code == 1013:
+		// connect timeout, user requested cancel
+		e.Code, e.Status = "DB_TIMEOUT", http.StatusGatewayTimeout
+	case code == 54 || code == 60 || code == 4021 || code == 30006:
+		// resource busy, deadlock, lock wait timeout
+		e.Code, e.Status = "DB_BUSY", http.StatusServiceUnavailable
+	case code >= 20000 && code <= 20999:
+		// raise_application_error from the report procedures
+		e.Code, e.Status = "DB_PROCEDURE_ERROR", http.StatusUnprocessableEntity
+	case code == 942 || code == 4043 || code == 6550 || code == 4068 || code == 6508:
+		// missing table or package, invalid PL/SQL, discarded package state
+		e.Code, e.Status = "DB_SCHEMA_ERROR", http.StatusBadGateway
+	}
+	return e
+}
+
+// AsError classifies any error returned by the service layer. Errors that
+// are not *Error are mapped through the package's sentinel errors; the rest
+// are internal.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var unknownSegment *models.UnknownSegmentError
+	var inProgress *JobInProgressError
+	switch {
+	case errors.As(err, &unknownSegment):
+		return &Error{Kind: KindNotFound, Code: "UNKNOWN_SEGMENT", Status: http.StatusNotFound, Err: err}
+	case errors.Is(err, ErrFileNotFound):
+		return &Error{Kind: KindNotFound, Code: "FILE_NOT_FOUND", Status: http.StatusNotFound, Err: err}
+	case errors.Is(err, ErrJobNotFound):
+		return &Error{Kind: KindNotFound, Code: "JOB_NOT_FOUND", Status: http.StatusNotFound, Err: err}
+	case errors.As(err, &inProgress):
+		return &Error{Kind: KindConflict, Code: "JOB_IN_PROGRESS", Status: http.StatusConflict, Err: err}
+	case errors.Is(err, ErrAlreadyGenerated):
+		return &Error{Kind: KindConflict, Code: "ALREADY_GENERATED", Status: http.StatusConflict, Err: err}
+	case errors.Is(err, ErrValidationFailed):
+		return &Error{Kind: KindValidation, Code: "VALIDATION_FAILED", Status: http.StatusUnprocessableEntity, Err: err}
+	case errors.Is(err, ErrRareCharacters):
+		return &Error{Kind: KindValidation, Code: "RARE_CHARACTERS", Status: http.StatusUnprocessableEntity, Err: err}
+	}
+	return &Error{Kind: KindInternal, Code: "INTERNAL", Status: http.StatusInternalServerError, Err: err}
+}
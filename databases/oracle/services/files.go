@@ -0,0 +1,395 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrFileNotFound is returned when a requested output file does not exist
+// or lies outside <root>/cxnvol
+var ErrFileNotFound = errors.New("file not found")
+
+// generatedNamePattern matches <type>-<date>-<segment>[.v<N>] outputs: CSV
+// files, XML directories, previous versions and their retention archives
+var generatedNamePattern = regexp.MustCompile(`^(C\d{4})-(\d{8})-([A-Za-z0-9]+)(?:\.v(\d+))?(\.csv|\.csv\.gz|\.tar\.gz)?$`)
+
+// GeneratedFile describes one output under cxnvol/<segment>
+type GeneratedFile struct {
+	Name        string    `json:"name"`
+	MessageType string    `json:"message_type"`
+	InvoiceDate string    `json:"invoice_date"`
+	SegmentNo   string    `json:"segment_no"`
+	Version     int       `json:"version,omitempty"`
+	Dir         bool      `json:"dir"`
+	Archived    bool      `json:"archived"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// outputRoot opens <rootDir>/cxnvol as an os.Root so that no lookup can
+// escape it, whatever the segment or file name contains
+func outputRoot(rootDir string) (*os.Root, error) {
+	dir := filepath.Join(rootDir, "cxnvol")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+	return os.OpenRoot(dir)
+}
+
+// validSegmentDir rejects segment values that are not a single path element
+func validSegmentDir(segmentNo string) bool {
+	return segmentNo != "" && segmentNo != "." && segmentNo != ".." && !strings.ContainsAny(segmentNo, `/\`)
+}
+
+// ListFiles lists the generated outputs of a segment whose invoice date is
+// within [from, to] (YYYYMMDD, either may be empty)
+func (s *InvoiceService) ListFiles(segmentNo, from, to string) ([]GeneratedFile, error) {
+	segmentNo = strings.ToUpper(segmentNo)
+	return listFiles(s.rootDirFor(segmentNo), segmentNo, from, to)
+}
+
+// listFiles lists the outputs under <rootDir>/cxnvol/<segment>
+func listFiles(rootDir, segmentNo, from, to string) ([]GeneratedFile, error) {
+	if !validSegmentDir(segmentNo) {
+		return nil, ErrFileNotFound
+	}
+
+	root, err := outputRoot(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	entries, err := fs.ReadDir(root.FS(), segmentNo)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []GeneratedFile{}, nil
+	}
+	if err != nil {
+		return nil, storageError("reading directory", err)
+	}
+
+	files := []GeneratedFile{}
+	for _, entry := range entries {
+		m := generatedNamePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		if (entry.IsDir() && m[5] != "") || (!entry.IsDir() && m[5] == "") {
+			continue
+		}
+		// Outputs are never symlinks; OpenDownload refuses them too
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			continue
+		}
+		invoiceDate := m[2]
+		if (from != "" && invoiceDate < from) || (to != "" && invoiceDate > to) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[4])
+		files = append(files, GeneratedFile{
+			Name:        entry.Name(),
+			MessageType: m[1],
+			InvoiceDate: invoiceDate,
+			SegmentNo:   segmentNo,
+			Version:     version,
+			Dir:         entry.IsDir(),
+			Archived:    strings.HasSuffix(entry.Name(), ".gz"),
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].InvoiceDate != files[j].InvoiceDate {
+			return files[i].InvoiceDate < files[j].InvoiceDate
+		}
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// Download is an output file ready to be served. Close removes any
+// temporary file created for it.
+type Download struct {
+	File     *os.File
+	Name     string
+	ModTime  time.Time
+	Checksum string // hex SHA-256
+	cleanup  func()
+}
+
+func (d *Download) Close() error {
+	err := d.File.Close()
+	if d.cleanup != nil {
+		d.cleanup()
+	}
+	return err
+}
+
+// OpenDownload opens cxnvol/<segment>/<name> for download. name may point
+// at a file inside an XML directory; a directory itself is served as zip.
+// Symlinks are refused, so that a link cannot point outside the segment.
+func (s *InvoiceService) OpenDownload(segmentNo, name string) (*Download, error) {
+	segmentNo = strings.ToUpper(segmentNo)
+	name = strings.TrimPrefix(name, "/")
+	if !validSegmentDir(segmentNo) || name == "" {
+		return nil, ErrFileNotFound
+	}
+	rel := path.Join(segmentNo, name)
+	if !fs.ValidPath(rel) || !strings.HasPrefix(rel, segmentNo+"/") {
+		return nil, ErrFileNotFound
+	}
+
+	root, err := outputRoot(s.rootDirFor(segmentNo))
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	info, err := root.Lstat(rel)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.Mode()&fs.ModeSymlink != 0) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, storageError("stat "+rel, err)
+	}
+
+	if info.IsDir() {
+		return s.zipDirectory(root, rel)
+	}
+
+	file, err := root.Open(rel)
+	if err != nil {
+		return nil, storageError("opening "+rel, err)
+	}
+	checksum, err := sha256Reader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Download{
+		File:     file,
+		Name:     path.Base(rel),
+		ModTime:  info.ModTime(),
+		Checksum: checksum,
+	}, nil
+}
+
+// zipDirectory packs an XML output directory into a temporary zip file
+func (s *InvoiceService) zipDirectory(root *os.Root, rel string) (*Download, error) {
+	tmp, err := os.CreateTemp("", "c0401-*.zip")
+	if err != nil {
+		return nil, storageError("creating zip", err)
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	zw := zip.NewWriter(tmp)
+	if err := zw.AddFS(subFS(root, rel)); err != nil {
+		tmp.Close()
+		cleanup()
+		return nil, storageError("zipping "+rel, err)
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		cleanup()
+		return nil, storageError("zipping "+rel, err)
+	}
+
+	checksum, err := sha256Reader(tmp)
+	if err != nil {
+		tmp.Close()
+		cleanup()
+		return nil, err
+	}
+
+	return &Download{
+		File:     tmp,
+		Name:     path.Base(rel) + ".zip",
+		ModTime:  time.Now(),
+		Checksum: checksum,
+		cleanup:  cleanup,
+	}, nil
+}
+
+func subFS(root *os.Root, dir string) fs.FS {
+	sub, err := fs.Sub(root.FS(), dir)
+	if err != nil {
+		// dir was validated by the caller
+		panic(err)
+	}
+	return sub
+}
+
+// sha256Reader hashes f from the start and rewinds it
+func sha256Reader(f *os.File) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("seeking: %w", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("seeking: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// RetentionMode selects what happens to outputs past their retention period
+type RetentionMode string
+
+const (
+	// RetentionArchive gzips CSV files and tars+gzips XML directories
+	RetentionArchive RetentionMode = "archive"
+	// RetentionDelete removes outputs and archives
+	RetentionDelete RetentionMode = "delete"
+)
+
+// RetentionPolicy applies Mode to outputs not modified for MaxAge
+type RetentionPolicy struct {
+	Mode   RetentionMode
+	MaxAge time.Duration
+}
+
+// RetentionReport lists the outputs touched by ApplyRetention
+type RetentionReport struct {
+	Archived []string `json:"archived"`
+	Deleted  []string `json:"deleted"`
+}
+
+// ApplyRetention archives or deletes outputs of every segment, under the
+// default and every segment output root, older than policy.MaxAge. In
+// archive mode existing archives are left alone.
+func (s *InvoiceService) ApplyRetention(policy RetentionPolicy) (*RetentionReport, error) {
+	if policy.Mode != RetentionArchive && policy.Mode != RetentionDelete {
+		return nil, fmt.Errorf("unsupported retention mode: %s", policy.Mode)
+	}
+
+	report := &RetentionReport{Archived: []string{}, Deleted: []string{}}
+	var errs []error
+	for _, rootDir := range s.rootDirs() {
+		if err := applyRetention(rootDir, policy, report); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// applyRetention processes the segments under <rootDir>/cxnvol
+func applyRetention(rootDir string, policy RetentionPolicy, report *RetentionReport) error {
+	root, err := outputRoot(rootDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	segments, err := fs.ReadDir(root.FS(), ".")
+	if err != nil {
+		return fmt.Errorf("reading directory: %w", err)
+	}
+
+	// rel comes from listFiles, so it is always <segment>/<generated name>
+	base := filepath.Join(rootDir, "cxnvol")
+	cutoff := time.Now().Add(-policy.MaxAge)
+	var errs []error
+	for _, segment := range segments {
+		if !segment.IsDir() {
+			continue
+		}
+		files, err := listFiles(rootDir, segment.Name(), "", "")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, f := range files {
+			if !f.ModTime.Before(cutoff) {
+				continue
+			}
+			rel := path.Join(f.SegmentNo, f.Name)
+			switch {
+			case policy.Mode == RetentionDelete:
+				target := filepath.Join(base, filepath.FromSlash(rel))
+				if err := os.RemoveAll(target); err != nil {
+					errs = append(errs, fmt.Errorf("deleting %s: %w", rel, err))
+					continue
+				}
+				os.Remove(manifestPath(strings.TrimSuffix(strings.TrimSuffix(target, ".gz"), ".tar")))
+				report.Deleted = append(report.Deleted, rel)
+			case !f.Archived:
+				if err := archiveOutput(root, base, rel, f.Dir); err != nil {
+					errs = append(errs, fmt.Errorf("archiving %s: %w", rel, err))
+					continue
+				}
+				report.Archived = append(report.Archived, rel)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// archiveOutput replaces a CSV file with <name>.gz or an XML directory with
+// <name>.tar.gz
+func archiveOutput(root *os.Root, base, rel string, dir bool) error {
+	archive := rel + ".gz"
+	if dir {
+		archive = rel + ".tar.gz"
+	}
+
+	out, err := root.Create(archive + ".tmp")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+
+	if dir {
+		tw := tar.NewWriter(gz)
+		err = tw.AddFS(subFS(root, rel))
+		if err == nil {
+			err = tw.Close()
+		}
+	} else {
+		var in *os.File
+		in, err = root.Open(rel)
+		if err == nil {
+			_, err = io.Copy(gz, in)
+			in.Close()
+		}
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(filepath.Join(base, filepath.FromSlash(archive+".tmp")), filepath.Join(base, filepath.FromSlash(archive)))
+	}
+	if err != nil {
+		root.Remove(archive + ".tmp")
+		return err
+	}
+
+	return os.RemoveAll(filepath.Join(base, filepath.FromSlash(rel)))
+}
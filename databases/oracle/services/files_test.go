@@ -0,0 +1,152 @@
+package services
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newFilesService lays out two segments under a temporary root:
+//
+//	secret.txt                          outside cxnvol
+//	cxnvol/LP/C0401-20250701-LP.csv
+//	cxnvol/LP/C0401-20250702-LP/        an XML output
+//	cxnvol/LP/C0401-20250703-LP.csv  -> ../../secret.txt
+//	cxnvol/LP/C0401-20250704-LP.csv  -> <root>/secret.txt
+//	cxnvol/ND/C0401-20250701-ND.csv
+func newFilesService(t *testing.T) *InvoiceService {
+	t.Helper()
+	root := t.TempDir()
+	write := func(rel, content string) {
+		name := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("secret.txt", "secret")
+	write("cxnvol/LP/C0401-20250701-LP.csv", "H|LP\n")
+	write("cxnvol/LP/C0401-20250702-LP/AB12345678.xml", "<Invoice/>")
+	write("cxnvol/LP/C0401-20250702-LP/AB12345679.xml", "<Invoice/>")
+	write("cxnvol/ND/C0401-20250701-ND.csv", "H|ND\n")
+
+	lp := filepath.Join(root, "cxnvol", "LP")
+	if err := os.Symlink("../../secret.txt", filepath.Join(lp, "C0401-20250703-LP.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "secret.txt"), filepath.Join(lp, "C0401-20250704-LP.csv")); err != nil {
+		t.Fatal(err)
+	}
+	return NewInvoiceService(root, NewMemoryInvoiceSource())
+}
+
+// rejected reports whether err is a not-found or validation error
+func rejected(err error) bool {
+	if err == nil {
+		return false
+	}
+	kind := AsError(err).Kind
+	return kind == KindNotFound || kind == KindValidation
+}
+
+func TestOpenDownloadRejectsEscapes(t *testing.T) {
+	s := newFilesService(t)
+
+	tests := []struct {
+		name          string
+		segment, file string
+	}{
+		{"parent of the segment", "LP", "../ND/C0401-20250701-ND.csv"},
+		{"parent of cxnvol", "LP", "../../secret.txt"},
+		{"parent inside the name", "LP", "C0401-20250702-LP/../../ND/C0401-20250701-ND.csv"},
+		{"absolute name", "LP", "/etc/passwd"},
+		{"double slash name", "LP", "//etc/passwd"},
+		{"absolute segment", "/etc", "passwd"},
+		{"parent segment", "..", "secret.txt"},
+		{"segment with a slash", "LP/../ND", "C0401-20250701-ND.csv"},
+		{"segment with a backslash", `LP\..\ND`, "C0401-20250701-ND.csv"},
+		{"name of another segment", "LP", "C0401-20250701-ND.csv"},
+		{"relative symlink out of cxnvol", "LP", "C0401-20250703-LP.csv"},
+		{"absolute symlink out of cxnvol", "LP", "C0401-20250704-LP.csv"},
+		{"empty name", "LP", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			download, err := s.OpenDownload(tt.segment, tt.file)
+			if err == nil {
+				download.Close()
+				t.Fatalf("OpenDownload(%q, %q) served %s", tt.segment, tt.file, download.Name)
+			}
+			if !rejected(err) {
+				t.Errorf("err = %v (kind %s), want not_found or validation", err, AsError(err).Kind)
+			}
+		})
+	}
+}
+
+func TestListFilesRejectsEscapes(t *testing.T) {
+	s := newFilesService(t)
+
+	for _, segment := range []string{"", ".", "..", "../ND", "/etc", "LP/../ND", `..\ND`} {
+		if files, err := s.ListFiles(segment, "", ""); !rejected(err) {
+			t.Errorf("ListFiles(%q) = %v, %v; want not_found or validation", segment, files, err)
+		}
+	}
+
+	files, err := s.ListFiles("lp", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	want := []string{"C0401-20250701-LP.csv", "C0401-20250702-LP"}
+	if !slices.Equal(names, want) {
+		t.Errorf("files = %q, want %q without the symlinks", names, want)
+	}
+}
+
+func TestOpenDownloadDirectoryAsZip(t *testing.T) {
+	s := newFilesService(t)
+
+	download, err := s.OpenDownload("LP", "C0401-20250702-LP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer download.Close()
+	if download.Name != "C0401-20250702-LP.zip" {
+		t.Errorf("Name = %q, want C0401-20250702-LP.zip", download.Name)
+	}
+
+	info, err := download.File.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(download.File, info.Size())
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"AB12345678.xml", "AB12345679.xml"}; !slices.Equal(names, want) {
+		t.Errorf("zip holds %q, want %q", names, want)
+	}
+
+	// A file inside the directory is served as it is
+	single, err := s.OpenDownload("LP", "C0401-20250702-LP/AB12345678.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer single.Close()
+	if body, _ := io.ReadAll(single.File); string(body) != "<Invoice/>" {
+		t.Errorf("body = %q", body)
+	}
+}
@@ -1,22 +1,31 @@
-package service
+package services
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"iter"
 	"log"
+	"net/http"
 	"oracle-demo/models"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/gin-gonic/gin"
-	_ "github.com/sijms/go-ora/v2"
 )
 
 // InvoiceRow represents a row from the database query
-// Using sql.NullString to properly handle Oracle NULLs
+// Using sql.NullString and models.NullDecimal to properly handle Oracle NULLs.
+// Amounts are exact decimals scanned from NUMBER columns; each output writer
+// formats them with its own rules.
 type InvoiceRow struct {
 	InvoiceNumber        sql.NullString
 	InvoiceDate          sql.NullString
@@ -26,13 +35,13 @@ type InvoiceRow struct {
 	BuyerAddress         sql.NullString
 	BuyerTelephoneNumber sql.NullString
 	BuyerEmailAddress    sql.NullString
-	SalesAmount          sql.NullString
-	FreeTaxSalesAmount   sql.NullString
-	ZeroTaxSalesAmount   sql.NullString
+	SalesAmount          models.NullDecimal
+	FreeTaxSalesAmount   models.NullDecimal
+	ZeroTaxSalesAmount   models.NullDecimal
 	TaxType              sql.NullString
-	TaxRate              sql.NullString
-	TaxAmount            sql.NullString
-	TotalAmount          sql.NullString
+	TaxRate              models.NullDecimal
+	TaxAmount            models.NullDecimal
+	TotalAmount          models.NullDecimal
 	PrintMark            sql.NullString
 	RandomNumber         sql.NullString
 	MainRemark           sql.NullString
@@ -42,269 +51,515 @@ type InvoiceRow struct {
 	NPOBAN               sql.NullString
 	LineNo               int
 	Description          sql.NullString
-	Quantity             sql.NullString
-	UnitPrice            sql.NullString
-	Amount               sql.NullString
+	Quantity             models.NullDecimal
+	UnitPrice            models.NullDecimal
+	Amount               models.NullDecimal
 	DetailTaxType        sql.NullString
 	Remark               sql.NullString
 }
 
 // CSVGenerationResult represents the result of CSV generation
 type CSVGenerationResult struct {
-	InvoiceCount int
-	TotalRows    int
-	FilePath     string
+	InvoiceCount int               `json:"invoice_count"`
+	TotalRows    int               `json:"total_rows"`
+	FilePath     string            `json:"file_path"`
+	Validation   *ValidationReport `json:"validation"`
+	// RareCharacters lists every rare character found in free-text columns
+	RareCharacters []RareCharHit `json:"rare_characters"`
+}
+
+// MessageType is a MIG message generated by InvoiceService
+type MessageType string
+
+const (
+	// MessageC0401 is an invoice issue
+	MessageC0401 MessageType = "C0401"
+	// MessageC0501 is an invoice cancellation
+	MessageC0501 MessageType = "C0501"
+	// MessageC0701 is an invoice void
+	MessageC0701 MessageType = "C0701"
+)
+
+// OutputFormat selects the file format written by the generators
+type OutputFormat string
+
+const (
+	// FormatCSV writes a flat CSV with a trailing "Finish" marker
+	FormatCSV OutputFormat = "csv"
+	// FormatXML writes one MIG 4.x XML document per invoice
+	FormatXML OutputFormat = "xml"
+)
+
+// ParseOutputFormat converts a request parameter into an OutputFormat.
+// An empty value defaults to CSV.
+func ParseOutputFormat(format string) (OutputFormat, error) {
+	switch OutputFormat(strings.ToLower(format)) {
+	case "", FormatCSV:
+		return FormatCSV, nil
+	case FormatXML:
+		return FormatXML, nil
+	default:
+		return "", validationError("INVALID_FORMAT", "unsupported output format: %s", format)
+	}
 }
 
 // InvoiceService handles invoice-related operations
 type InvoiceService struct {
-	rootDir string
+	rootDir          string
+	source           InvoiceSource
+	strictValidation bool
+	regeneratePolicy RegeneratePolicy
+	rareCharPolicy   RareCharPolicy
+
+	segmentsMu   sync.RWMutex
+	segmentRoots map[string]string        // segment -> root dir overriding rootDir
+	issuers      map[string]SegmentIssuer // segment -> seller of its C0401 XML
 }
 
-// NewInvoiceService creates a new InvoiceService
-func NewInvoiceService(rootDir string) *InvoiceService {
+// NewInvoiceService creates a new InvoiceService reading from source.
+// Regenerating an existing output versions the previous one.
+func NewInvoiceService(rootDir string, source InvoiceSource) *InvoiceService {
 	return &InvoiceService{
-		rootDir: rootDir,
+		rootDir:          rootDir,
+		source:           source,
+		regeneratePolicy: RegenerateVersion,
 	}
 }
 
-// GenC0401 generates C0401 CSV file from invoice data
-func (s *InvoiceService) GenC0401(segmentNo, invoiceDate string) (*CSVGenerationResult, error) {
-	db, err := models.GetOracleConnection()
-	if err != nil {
-		return nil, fmt.Errorf("getting database connection: %w", err)
+// SetSegmentRootDirs sets the output roots of segments that do not write
+// under the default root directory, replacing earlier ones
+func (s *InvoiceService) SetSegmentRootDirs(roots map[string]string) {
+	upper := make(map[string]string, len(roots))
+	for segment, dir := range roots {
+		if dir != "" {
+			upper[strings.ToUpper(segment)] = dir
+		}
+	}
+	s.segmentsMu.Lock()
+	defer s.segmentsMu.Unlock()
+	s.segmentRoots = upper
+}
+
+// SetSegmentIssuers sets the seller and invoice type written into the C0401
+// XML of each segment, replacing earlier ones
+func (s *InvoiceService) SetSegmentIssuers(issuers map[string]SegmentIssuer) {
+	upper := make(map[string]SegmentIssuer, len(issuers))
+	for segment, issuer := range issuers {
+		upper[strings.ToUpper(segment)] = issuer
+	}
+	s.segmentsMu.Lock()
+	defer s.segmentsMu.Unlock()
+	s.issuers = upper
+}
+
+// issuerFor returns the issuer of a segment's C0401 XML. Without a seller
+// the document would fail the MIG schema, so it is not written at all.
+func (s *InvoiceService) issuerFor(segmentNo string) (SegmentIssuer, error) {
+	s.segmentsMu.RLock()
+	issuer, ok := s.issuers[strings.ToUpper(segmentNo)]
+	s.segmentsMu.RUnlock()
+	if !ok || issuer.Seller.Identifier == "" {
+		return SegmentIssuer{}, &Error{
+			Kind:    KindValidation,
+			Code:    "SELLER_NOT_CONFIGURED",
+			Status:  http.StatusUnprocessableEntity,
+			Message: fmt.Sprintf("segment %s has no seller configured; C0401 XML requires one", segmentNo),
+		}
+	}
+	if issuer.InvoiceType == "" {
+		issuer.InvoiceType = defaultInvoiceType
+	}
+	return issuer, nil
+}
+
+// rootDirFor returns the output root of a segment
+func (s *InvoiceService) rootDirFor(segmentNo string) string {
+	s.segmentsMu.RLock()
+	defer s.segmentsMu.RUnlock()
+	if dir, ok := s.segmentRoots[strings.ToUpper(segmentNo)]; ok {
+		return dir
+	}
+	return s.rootDir
+}
+
+// rootDirs lists the default root and every segment root, without duplicates
+func (s *InvoiceService) rootDirs() []string {
+	s.segmentsMu.RLock()
+	defer s.segmentsMu.RUnlock()
+	dirs := []string{s.rootDir}
+	for _, dir := range s.segmentRoots {
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs[1:])
+	return dirs
+}
+
+// Segments lists the configured segments
+func (s *InvoiceService) Segments() []string {
+	return s.source.Segments()
+}
+
+// KnownSegment reports whether segmentNo is configured in the source
+func (s *InvoiceService) KnownSegment(segmentNo string) bool {
+	return slices.Contains(s.source.Segments(), strings.ToUpper(segmentNo))
+}
+
+// CheckSupported fails with REPORT_NOT_CONFIGURED when the source cannot
+// produce messageType, so that no job is queued for it
+func (s *InvoiceService) CheckSupported(messageType MessageType) error {
+	source, ok := s.source.(interface{ Supports(MessageType) bool })
+	if ok && !source.Supports(messageType) {
+		return reportNotConfiguredError(messageType)
+	}
+	return nil
+}
+
+// SetStrictValidation makes the generators refuse to write any file when
+// the rows fail validation
+func (s *InvoiceService) SetStrictValidation(strict bool) {
+	s.strictValidation = strict
+}
+
+// Generate runs the generator of messageType
+func (s *InvoiceService) Generate(messageType MessageType, segmentNo, invoiceDate string, format OutputFormat) (*CSVGenerationResult, error) {
+	switch messageType {
+	case MessageC0401:
+		return s.GenC0401(segmentNo, invoiceDate, format)
+	case MessageC0501:
+		return s.GenC0501(segmentNo, invoiceDate, format)
+	case MessageC0701:
+		return s.GenC0701(segmentNo, invoiceDate, format)
+	default:
+		return nil, validationError("INVALID_MESSAGE_TYPE", "unsupported message type: %s", messageType)
 	}
-	defer db.Close()
+}
+
+// GenC0401 generates C0401 CSV or XML files from invoice data
+func (s *InvoiceService) GenC0401(segmentNo, invoiceDate string, format OutputFormat) (*CSVGenerationResult, error) {
+	session := &lazySession{source: s.source, segmentNo: segmentNo}
+	defer session.Close()
+	return s.genC0401(session, segmentNo, invoiceDate, format)
+}
 
+// genC0401 generates one C0401 output, opening the session only once the
+// regenerate policy allows the output to be written
+func (s *InvoiceService) genC0401(lazy *lazySession, segmentNo, invoiceDate string, format OutputFormat) (*CSVGenerationResult, error) {
 	// Parse invoice date
 	parsedDate, err := time.Parse("20060102", invoiceDate)
 	if err != nil {
-		return nil, fmt.Errorf("parsing invoice date: %w", err)
+		return nil, validationError("INVALID_DATE", "invoice date must be YYYYMMDD: %q", invoiceDate)
 	}
 
-	// Call stored procedures
-	if err := s.callStoredProcedures(db, segmentNo, parsedDate); err != nil {
-		return nil, fmt.Errorf("calling stored procedures: %w", err)
+	outputPath := s.outputPath(MessageC0401, segmentNo, invoiceDate, format)
+	if err := s.checkRegenerate(outputPath); err != nil {
+		return nil, err
+	}
+	var issuer SegmentIssuer
+	if format == FormatXML {
+		if issuer, err = s.issuerFor(segmentNo); err != nil {
+			return nil, err
+		}
 	}
 
-	// Execute main query
-	rows, err := s.executeQuery(db)
+	session, err := lazy.get()
 	if err != nil {
-		return nil, fmt.Errorf("executing query: %w", err)
+		return nil, fmt.Errorf("opening invoice source: %w", err)
+	}
+
+	// Fill the report table for the date
+	if err := session.PrepareC0401(parsedDate); err != nil {
+		lazy.Close()
+		return nil, fmt.Errorf("preparing C0401 data: %w", err)
 	}
 
-	// Generate CSV file
-	result, err := s.generateCSVFile(rows, segmentNo, invoiceDate)
+	// Stream rows from the main query straight into the output writer
+	var writer rowWriter[InvoiceRow]
+	switch format {
+	case FormatXML:
+		writer, err = s.newXMLWriter(outputPath, issuer)
+	default:
+		writer, err = newCSVWriter(s, outputPath, c0401CSVHeader, s.c0401Record)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("generating CSV file: %w", err)
+		return nil, storageError(fmt.Sprintf("creating %s writer", format), err)
 	}
 
-	return result, nil
+	result, err := s.writeRows(session.C0401Rows(), writer, &Manifest{
+		MessageType: MessageC0401,
+		SegmentNo:   segmentNo,
+		InvoiceDate: invoiceDate,
+		Format:      format,
+	})
+	if err != nil && result == nil {
+		// the session may be broken; the next generation opens a new one
+		lazy.Close()
+	}
+	return result, err
 }
 
-// callStoredProcedures calls the required Oracle stored procedures
-func (s *InvoiceService) callStoredProcedures(db *sql.DB, segmentNo string, invoiceDate time.Time) error {
-	// Call PK_ERP.P_SET_SEGMENT_NO
-	_, err := db.Exec("BEGIN PK_ERP.P_SET_SEGMENT_NO(:1); END;", strings.ToUpper(segmentNo))
-	if err != nil {
-		return fmt.Errorf("calling PK_ERP.P_SET_SEGMENT_NO: %w", err)
+// lazySession opens a session on first use and keeps it for the following
+// generations of the same segment
+type lazySession struct {
+	source    InvoiceSource
+	segmentNo string
+	session   InvoiceSession
+}
+
+func (l *lazySession) get() (InvoiceSession, error) {
+	if l.session == nil {
+		session, err := l.source.Open(l.segmentNo)
+		if err != nil {
+			return nil, err
+		}
+		l.session = session
 	}
+	return l.session, nil
+}
 
-	// Call ARGOERP.P_RPT_MRIF004
-	_, err = db.Exec("BEGIN ARGOERP.P_RPT_MRIF004(:1); END;", invoiceDate)
-	if err != nil {
-		return fmt.Errorf("calling ARGOERP.P_RPT_MRIF004: %w", err)
+// Close closes the session if one was opened
+func (l *lazySession) Close() error {
+	if l.session == nil {
+		return nil
 	}
+	err := l.session.Close()
+	l.session = nil
+	return err
+}
 
-	return nil
+// outputPath returns <root>/cxnvol/<segment>/<type>-<date>-<segment>.csv
+// for CSV and the <type>-<date>-<segment> directory for XML. The segment is
+// upper-cased, as ListFiles and OpenDownload look it up.
+func (s *InvoiceService) outputPath(messageType MessageType, segmentNo, invoiceDate string, format OutputFormat) string {
+	segmentNo = strings.ToUpper(segmentNo)
+	name := fmt.Sprintf("%s-%s-%s", messageType, invoiceDate, segmentNo)
+	if format != FormatXML {
+		name += ".csv"
+	}
+	return filepath.Join(s.rootDirFor(segmentNo), "cxnvol", segmentNo, name)
 }
 
-// executeQuery executes the main data retrieval query
-// Using TRIM with proper NULL handling for Oracle
-func (s *InvoiceService) executeQuery(db *sql.DB) ([]InvoiceRow, error) {
-	query := `
-		SELECT TRIM(var_attr01) InvoiceNumber,
-			TRIM(DECODE(num_attr08,1,TO_CHAR(date_attr01,'YYYYMMDD'),'')) InvoiceDate,
-			TRIM(DECODE(num_attr08,1,var_attr02,'')) InvoiceTime,        
-			TRIM(DECODE(num_attr08,1,var_attr03,'')) BuyerIdentifier,    
-			TRIM(DECODE(num_attr08,1,var_attr04,'')) BuyerName,          
-			TRIM(DECODE(num_attr08,1,var_attr05,'')) BuyerAddress,       
-			TRIM(DECODE(num_attr08,1,var_attr06,'')) BuyerTelephoneNumber,
-			TRIM(DECODE(num_attr08,1,var_attr07,'')) BuyerEmailAddress,  
-			TRIM(TO_CHAR(DECODE(num_attr08,1,num_attr01,NULL))) SalesAmount,        
-			TRIM(TO_CHAR(DECODE(num_attr08,1,num_attr02,NULL))) FreeTaxSalesAmount, 
-			TRIM(TO_CHAR(DECODE(num_attr08,1,num_attr03,NULL))) ZeroTaxSalesAmount, 
-			TRIM(DECODE(num_attr08,1,var_attr08,'')) TaxType,            
-			TRIM(DECODE(num_attr08,1,TO_CHAR(num_attr04,'0.99'),'')) TaxRate,
-			TRIM(TO_CHAR(DECODE(num_attr08,1,num_attr05,NULL))) TaxAmount,   
-			TRIM(TO_CHAR(DECODE(num_attr08,1,num_attr06,NULL))) TotalAmount, 
-			TRIM(DECODE(num_attr08,1,var_attr09,'')) PrintMark,   
-			TRIM(TO_CHAR(DECODE(num_attr08,1,num_attr07,NULL))) RandomNumber,
-			TRIM(DECODE(num_attr08,1,var_attr10,'')) MainRemark,  
-			TRIM(DECODE(num_attr08,1,var_attr11,'')) CarrierType, 
-			TRIM(DECODE(num_attr08,1,var_attr12,'')) CarrierId1,  
-			TRIM(DECODE(num_attr08,1,var_attr13,'')) CarrierId2,  
-			TRIM(DECODE(num_attr08,1,var_attr14,'')) NPOBAN,      
-			num_attr08 line_no,    
-			TRIM(var_attr15) Description,
-			TRIM(TO_CHAR(num_attr09)) Quantity,   
-			TRIM(TO_CHAR(num_attr10)) UnitPrice,  
-			TRIM(TO_CHAR(num_attr11)) Amount,
-			TRIM(var_attr08) DetailTaxType,     
-			TRIM(var_attr16) Remark      
-		FROM argoerp.mr_global_temp a
-		WHERE a.pid = 'MRIF004'
-		ORDER BY a.var_attr01, a.num_attr08`
-
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("executing query: %w", err)
-	}
-	defer rows.Close()
-
-	var invoiceRows []InvoiceRow
-	for rows.Next() {
-		var row InvoiceRow
-		err := rows.Scan(
-			&row.InvoiceNumber,
-			&row.InvoiceDate,
-			&row.InvoiceTime,
-			&row.BuyerIdentifier,
-			&row.BuyerName,
-			&row.BuyerAddress,
-			&row.BuyerTelephoneNumber,
-			&row.BuyerEmailAddress,
-			&row.SalesAmount,
-			&row.FreeTaxSalesAmount,
-			&row.ZeroTaxSalesAmount,
-			&row.TaxType,
-			&row.TaxRate,
-			&row.TaxAmount,
-			&row.TotalAmount,
-			&row.PrintMark,
-			&row.RandomNumber,
-			&row.MainRemark,
-			&row.CarrierType,
-			&row.CarrierID1,
-			&row.CarrierID2,
-			&row.NPOBAN,
-			&row.LineNo,
-			&row.Description,
-			&row.Quantity,
-			&row.UnitPrice,
-			&row.Amount,
-			&row.DetailTaxType,
-			&row.Remark,
-		)
+// writeRows runs the C0401 rows through streamRows. An invoice is counted
+// on its line 1; the rare character policy applies to every line.
+func (s *InvoiceService) writeRows(rows iter.Seq2[InvoiceRow, error], writer rowWriter[InvoiceRow], manifest *Manifest) (*CSVGenerationResult, error) {
+	return streamRows(s, rows, writer, newC0401Validator(), manifest, func(row *InvoiceRow, result *CSVGenerationResult) {
+		if row.LineNo == 1 {
+			result.InvoiceCount++
+		}
+		if hits := s.applyRareCharPolicy(row); len(hits) > 0 {
+			log.Printf("Invoice %s line %d contains %d rare characters", row.InvoiceNumber.String, row.LineNo, len(hits))
+			result.RareCharacters = append(result.RareCharacters, hits...)
+		}
+	})
+}
+
+// rowValidator checks the rows of one message type as they stream past
+type rowValidator[T any] interface {
+	Add(row T)
+	Finish() *ValidationReport
+}
+
+// streamRows drives the row pipeline shared by every message type: each
+// row is passed to visit, which counts invoices and applies the rare
+// character policy, then validated and handed to the writer as it arrives,
+// so only the current invoice is held in memory. The output is committed
+// with its manifest only when the whole stream succeeded.
+func streamRows[T any](s *InvoiceService, rows iter.Seq2[T, error], writer rowWriter[T], validator rowValidator[T], manifest *Manifest, visit func(row *T, result *CSVGenerationResult)) (*CSVGenerationResult, error) {
+	result := &CSVGenerationResult{RareCharacters: []RareCharHit{}}
+
+	for row, err := range rows {
 		if err != nil {
-			return nil, fmt.Errorf("scanning row: %w", err)
+			writer.Abort()
+			return nil, fmt.Errorf("executing query: %w", err)
+		}
+
+		result.TotalRows++
+		visit(&row, result)
+
+		validator.Add(row)
+		if err := writer.WriteRow(row); err != nil {
+			writer.Abort()
+			return nil, storageError("writing row", err)
 		}
-		invoiceRows = append(invoiceRows, row)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterating rows: %w", err)
+	result.Validation = validator.Finish()
+	return commitOutput(s, writer, result, manifest)
+}
+
+// commitOutput applies the rare character and strict validation policies
+// to a fully streamed output and commits it with its manifest
+func commitOutput[T any](s *InvoiceService, writer rowWriter[T], result *CSVGenerationResult, manifest *Manifest) (*CSVGenerationResult, error) {
+	if s.rareCharPolicy.Mode == RareCharReject && len(result.RareCharacters) > 0 {
+		writer.Abort()
+		return result, fmt.Errorf("%w: %d occurrences", ErrRareCharacters, len(result.RareCharacters))
+	}
+	if s.strictValidation && !result.Validation.Valid() {
+		writer.Abort()
+		return result, fmt.Errorf("%w: %d of %d invoices invalid", ErrValidationFailed,
+			result.Validation.InvalidInvoiceCount, result.Validation.InvoiceCount)
 	}
 
-	return invoiceRows, nil
+	manifest.RowCount = result.TotalRows
+	manifest.InvoiceCount = result.InvoiceCount
+	manifest.GeneratedAt = time.Now()
+	path, err := writer.Commit(manifest)
+	if errors.Is(err, ErrAlreadyGenerated) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, storageError("committing output", err)
+	}
+	result.FilePath = path
+
+	return result, nil
+}
+
+// rowWriter receives rows in the order of the message's query (for C0401
+// InvoiceNumber, LineNo) and writes them to a temporary location until
+// Commit syncs the output and moves it into place with its manifest. Abort
+// discards everything written so far.
+type rowWriter[T any] interface {
+	WriteRow(row T) error
+	Commit(manifest *Manifest) (string, error)
+	Abort()
+}
+
+// c0401CSVHeader is the header row of the flat C0401 CSV
+var c0401CSVHeader = []string{
+	"InvoiceNumber", "InvoiceDate", "InvoiceTime", "BuyerIdentifier", "BuyerName",
+	"BuyerAddress", "BuyerTelephoneNumber", "BuyerEmailAddress", "SalesAmount",
+	"FreeTaxSalesAmount", "ZeroTaxSalesAmount", "TaxType", "TaxRate", "TaxAmount",
+	"TotalAmount", "PrintMark", "RandomNumber", "MainRemark", "CarrierType",
+	"CarrierId1", "CarrierId2", "NPOBAN", "Description", "Quantity",
+	"UnitPrice", "Amount", "DetailTaxType", "Remark",
 }
 
-// generateCSVFile creates the CSV file with invoice data
-func (s *InvoiceService) generateCSVFile(rows []InvoiceRow, segmentNo, invoiceDate string) (*CSVGenerationResult, error) {
+// csvWriter streams rows into a flat CSV, converting each one with record
+type csvWriter[T any] struct {
+	s        *InvoiceService
+	file     *os.File
+	hash     hash.Hash
+	writer   *csv.Writer
+	record   func(T) []string
+	tmpPath  string
+	filePath string
+}
+
+// newCSVWriter creates csvFilePath (written under a .tmp name until
+// committed) and writes the header
+func newCSVWriter[T any](s *InvoiceService, csvFilePath string, header []string, record func(T) []string) (*csvWriter[T], error) {
 	// Create directory path
-	csvDirPath := filepath.Join(s.rootDir, "cxnvol", segmentNo)
-	if err := os.MkdirAll(csvDirPath, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(csvFilePath), 0755); err != nil {
 		return nil, fmt.Errorf("creating directory: %w", err)
 	}
 
 	// Create CSV file
-	csvFileName := fmt.Sprintf("C0401-%s-%s.csv", invoiceDate, segmentNo)
-	csvFilePath := filepath.Join(csvDirPath, csvFileName)
-
-	file, err := os.Create(csvFilePath)
+	file, err := os.Create(csvFilePath + ".tmp")
 	if err != nil {
 		return nil, fmt.Errorf("creating CSV file: %w", err)
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
 
-	// Write header
-	header := []string{
-		"InvoiceNumber", "InvoiceDate", "InvoiceTime", "BuyerIdentifier", "BuyerName",
-		"BuyerAddress", "BuyerTelephoneNumber", "BuyerEmailAddress", "SalesAmount",
-		"FreeTaxSalesAmount", "ZeroTaxSalesAmount", "TaxType", "TaxRate", "TaxAmount",
-		"TotalAmount", "PrintMark", "RandomNumber", "MainRemark", "CarrierType",
-		"CarrierId1", "CarrierId2", "NPOBAN", "Description", "Quantity",
-		"UnitPrice", "Amount", "DetailTaxType", "Remark",
+	h := sha256.New()
+	w := &csvWriter[T]{
+		s:        s,
+		file:     file,
+		hash:     h,
+		writer:   csv.NewWriter(io.MultiWriter(file, h)),
+		record:   record,
+		tmpPath:  file.Name(),
+		filePath: csvFilePath,
 	}
 
-	if err := writer.Write(header); err != nil {
+	// Write header
+	if err := w.writer.Write(header); err != nil {
+		w.Abort()
 		return nil, fmt.Errorf("writing header: %w", err)
 	}
 
-	// Count invoices and process rows
-	invoiceCount := 0
-	for _, row := range rows {
-		if row.LineNo == 1 {
-			invoiceCount++
-		}
-
-		// Check for rare Unicode characters in BuyerName
-		buyerName := s.nullStringToString(row.BuyerName)
-		if s.containsRareUnicode(buyerName) {
-			log.Printf("BuyerName: %s contains rare characters", buyerName)
-		}
+	return w, nil
+}
 
-		// Convert row to CSV record - handle NULLs properly
-		record := []string{
-			s.nullStringToString(row.InvoiceNumber),
-			s.nullStringToString(row.InvoiceDate),
-			s.nullStringToString(row.InvoiceTime),
-			s.nullStringToString(row.BuyerIdentifier),
-			buyerName,
-			s.nullStringToString(row.BuyerAddress),
-			s.nullStringToString(row.BuyerTelephoneNumber),
-			s.nullStringToString(row.BuyerEmailAddress),
-			s.nullStringToString(row.SalesAmount),
-			s.nullStringToString(row.FreeTaxSalesAmount),
-			s.nullStringToString(row.ZeroTaxSalesAmount),
-			s.nullStringToString(row.TaxType),
-			s.nullStringToString(row.TaxRate),
-			s.nullStringToString(row.TaxAmount),
-			s.nullStringToString(row.TotalAmount),
-			s.nullStringToString(row.PrintMark),
-			s.nullStringToString(row.RandomNumber),
-			s.nullStringToString(row.MainRemark),
-			s.nullStringToString(row.CarrierType),
-			s.nullStringToString(row.CarrierID1),
-			s.nullStringToString(row.CarrierID2),
-			s.nullStringToString(row.NPOBAN),
-			s.nullStringToString(row.Description),
-			s.nullStringToString(row.Quantity),
-			s.nullStringToString(row.UnitPrice),
-			s.nullStringToString(row.Amount),
-			s.nullStringToString(row.DetailTaxType),
-			s.nullStringToString(row.Remark),
-		}
+// WriteRow writes the CSV record of a row
+func (w *csvWriter[T]) WriteRow(row T) error {
+	if err := w.writer.Write(w.record(row)); err != nil {
+		return fmt.Errorf("writing record: %w", err)
+	}
+	return nil
+}
 
-		if err := writer.Write(record); err != nil {
-			return nil, fmt.Errorf("writing record: %w", err)
-		}
+// c0401Record converts a row to a C0401 CSV record - handle NULLs properly
+func (s *InvoiceService) c0401Record(row InvoiceRow) []string {
+	return []string{
+		s.nullStringToString(row.InvoiceNumber),
+		s.nullStringToString(row.InvoiceDate),
+		s.nullStringToString(row.InvoiceTime),
+		s.nullStringToString(row.BuyerIdentifier),
+		s.nullStringToString(row.BuyerName),
+		s.nullStringToString(row.BuyerAddress),
+		s.nullStringToString(row.BuyerTelephoneNumber),
+		s.nullStringToString(row.BuyerEmailAddress),
+		csvNumberFormat.amount.format(row.SalesAmount),
+		csvNumberFormat.amount.format(row.FreeTaxSalesAmount),
+		csvNumberFormat.amount.format(row.ZeroTaxSalesAmount),
+		s.nullStringToString(row.TaxType),
+		csvNumberFormat.taxRate.format(row.TaxRate),
+		csvNumberFormat.amount.format(row.TaxAmount),
+		csvNumberFormat.amount.format(row.TotalAmount),
+		s.nullStringToString(row.PrintMark),
+		s.nullStringToString(row.RandomNumber),
+		s.nullStringToString(row.MainRemark),
+		s.nullStringToString(row.CarrierType),
+		s.nullStringToString(row.CarrierID1),
+		s.nullStringToString(row.CarrierID2),
+		s.nullStringToString(row.NPOBAN),
+		s.nullStringToString(row.Description),
+		csvNumberFormat.quantity.format(row.Quantity),
+		csvNumberFormat.unitPrice.format(row.UnitPrice),
+		csvNumberFormat.lineAmount.format(row.Amount),
+		s.nullStringToString(row.DetailTaxType),
+		s.nullStringToString(row.Remark),
 	}
+}
 
+// Commit writes the "Finish" marker, fsyncs the file and renames it into
+// place next to its manifest
+func (w *csvWriter[T]) Commit(manifest *Manifest) (string, error) {
 	// Write "Finish" marker
 	finishRecord := []string{"Finish"}
-	if err := writer.Write(finishRecord); err != nil {
-		return nil, fmt.Errorf("writing finish marker: %w", err)
+	if err := w.writer.Write(finishRecord); err != nil {
+		w.Abort()
+		return "", fmt.Errorf("writing finish marker: %w", err)
+	}
+
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.Abort()
+		return "", fmt.Errorf("flushing CSV: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		w.Abort()
+		return "", fmt.Errorf("syncing CSV file: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.tmpPath)
+		return "", fmt.Errorf("closing CSV file: %w", err)
+	}
+
+	manifest.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	if err := w.s.installOutput(w.tmpPath, w.filePath, manifest); err != nil {
+		os.Remove(w.tmpPath)
+		return "", err
 	}
 
-	return &CSVGenerationResult{
-		InvoiceCount: invoiceCount,
-		TotalRows:    len(rows),
-		FilePath:     csvFilePath,
-	}, nil
+	return w.filePath, nil
+}
+
+// Abort closes and removes the temporary file
+func (w *csvWriter[T]) Abort() {
+	w.file.Close()
+	os.Remove(w.tmpPath)
 }
 
 // Helper function for null value handling
@@ -314,38 +569,3 @@ func (s *InvoiceService) nullStringToString(ns sql.NullString) string {
 	}
 	return ""
 }
-
-// containsRareUnicode checks for rare Unicode characters (equivalent to your Python function)
-func (s *InvoiceService) containsRareUnicode(text string) bool {
-	for _, r := range text {
-		if r >= 0x20000 && r <= 0x2FFFF {
-			return true
-		}
-	}
-	return false
-}
-
-// Gin handler example
-func (s *InvoiceService) HandleGenC0401(c *gin.Context) {
-	segmentNo := c.Param("segment_no")
-	invoiceDate := c.Param("invoice_date")
-
-	if segmentNo == "" || invoiceDate == "" {
-		c.JSON(400, gin.H{"error": "segment_no and invoice_date are required"})
-		return
-	}
-
-	result, err := s.GenC0401(segmentNo, invoiceDate)
-	if err != nil {
-		log.Printf("Error generating C0401: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to generate CSV"})
-		return
-	}
-
-	c.JSON(200, gin.H{
-		"invoice_count": result.InvoiceCount,
-		"total_rows":    result.TotalRows,
-		"file_path":     result.FilePath,
-		"message":       "CSV generated successfully",
-	})
-}
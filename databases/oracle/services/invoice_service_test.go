@@ -0,0 +1,97 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"iter"
+	"oracle-demo/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// generatedRows yields n C0401 rows as the main query would, in invoices of
+// linesPerInvoice lines; only line 1 carries the header columns. Its own
+// two Sprintf calls per row are part of the reported allocations.
+func generatedRows(n, linesPerInvoice int) iter.Seq2[InvoiceRow, error] {
+	str := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	dec := func(v int64) models.NullDecimal {
+		return models.NullDecimal{Decimal: models.NewDecimal(v), Valid: true}
+	}
+	taxRate, _ := models.ParseDecimal("0.05")
+
+	return func(yield func(InvoiceRow, error) bool) {
+		for i := range n {
+			invoice, line := i/linesPerInvoice, i%linesPerInvoice+1
+			row := InvoiceRow{
+				InvoiceNumber: str(fmt.Sprintf("AB%08d", invoice)),
+				LineNo:        line,
+				Description:   str(fmt.Sprintf("Item %d", line)),
+				Quantity:      dec(1),
+				UnitPrice:     dec(100),
+				Amount:        dec(100),
+				DetailTaxType: str("1"),
+			}
+			if line == 1 {
+				lines := int64(min(linesPerInvoice, n-i))
+				row.InvoiceDate = str("20250701")
+				row.InvoiceTime = str("09:15:00")
+				row.BuyerIdentifier = str("0000000000")
+				row.BuyerName = str("0000")
+				row.SalesAmount = dec(100 * lines)
+				row.FreeTaxSalesAmount = dec(0)
+				row.ZeroTaxSalesAmount = dec(0)
+				row.TaxType = str("1")
+				row.TaxRate = models.NullDecimal{Decimal: taxRate, Valid: true}
+				row.TaxAmount = dec(5 * lines)
+				row.TotalAmount = dec(105 * lines)
+				row.PrintMark = str("N")
+				row.RandomNumber = str("0427")
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// discardWriter measures the pipeline without any output
+type discardWriter struct{}
+
+func (discardWriter) WriteRow(InvoiceRow) error        { return nil }
+func (discardWriter) Commit(*Manifest) (string, error) { return "", nil }
+func (discardWriter) Abort()                           {}
+
+func BenchmarkWriteRows(b *testing.B) {
+	const rows, linesPerInvoice = 10000, 3
+	s := NewInvoiceService(b.TempDir(), NewMemoryInvoiceSource())
+
+	// validation, rare character checks and counting only
+	b.Run("discard", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := s.writeRows(generatedRows(rows, linesPerInvoice), discardWriter{}, &Manifest{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// the full CSV output, including fsync and rename of the committed file
+	b.Run("csv", func(b *testing.B) {
+		path := filepath.Join(b.TempDir(), "C0401-20250701-LP.csv")
+		b.ReportAllocs()
+		for b.Loop() {
+			writer, err := newCSVWriter(s, path, c0401CSVHeader, s.c0401Record)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := s.writeRows(generatedRows(rows, linesPerInvoice), writer, &Manifest{}); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+			os.Remove(path)
+			os.Remove(manifestPath(path))
+			b.StartTimer()
+		}
+	})
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"oracle-demo/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InvoiceSource opens sessions against the invoice data of a segment
+type InvoiceSource interface {
+	Open(segmentNo string) (InvoiceSession, error)
+	// Segments lists the configured segment codes
+	Segments() []string
+}
+
+// InvoiceSession covers the stored procedure calls and the queries used to
+// produce C0401, C0501 and C0701 files. Each Prepare method must be called
+// before the matching Rows method.
+type InvoiceSession interface {
+	PrepareC0401(invoiceDate time.Time) error
+	C0401Rows() iter.Seq2[InvoiceRow, error]
+	PrepareC0501(cancelDate time.Time) error
+	C0501Rows() iter.Seq2[CancelVoidRow, error]
+	PrepareC0701(voidDate time.Time) error
+	C0701Rows() iter.Seq2[CancelVoidRow, error]
+	Close() error
+}
+
+// MemoryInvoiceSource replays recorded InvoiceRow and CancelVoidRow sets
+// keyed by message type, segment and date. It is used for demos and tests
+// without Oracle.
+type MemoryInvoiceSource struct {
+	mu          sync.RWMutex
+	rows        map[string][]InvoiceRow
+	cancelVoids map[string][]CancelVoidRow
+	segments    map[string]bool
+}
+
+// NewMemoryInvoiceSource creates an empty MemoryInvoiceSource
+func NewMemoryInvoiceSource() *MemoryInvoiceSource {
+	return &MemoryInvoiceSource{
+		rows:        make(map[string][]InvoiceRow),
+		cancelVoids: make(map[string][]CancelVoidRow),
+		segments:    make(map[string]bool),
+	}
+}
+
+// NewFixtureInvoiceSource loads every <dir>/<segment>/<YYYYMMDD>.json C0401
+// fixture and every <dir>/<segment>/C0501-<YYYYMMDD>.json and
+// C0701-<YYYYMMDD>.json fixture into a MemoryInvoiceSource
+func NewFixtureInvoiceSource(dir string) (*MemoryInvoiceSource, error) {
+	m := NewMemoryInvoiceSource()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("listing fixtures: %w", err)
+	}
+	for _, path := range paths {
+		segmentNo := filepath.Base(filepath.Dir(path))
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+
+		if messageType, date, ok := strings.Cut(name, "-"); ok {
+			rows, err := LoadCancelVoidFixtureFile(path)
+			if err != nil {
+				return nil, err
+			}
+			m.AddCancelVoid(MessageType(messageType), segmentNo, date, rows)
+			continue
+		}
+
+		rows, err := LoadFixtureFile(path)
+		if err != nil {
+			return nil, err
+		}
+		m.Add(segmentNo, name, rows)
+	}
+
+	return m, nil
+}
+
+// Add records the C0401 rows returned for segmentNo on invoiceDate (YYYYMMDD)
+func (m *MemoryInvoiceSource) Add(segmentNo, invoiceDate string, rows []InvoiceRow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rows[memoryKey(MessageC0401, segmentNo, invoiceDate)] = rows
+	m.segments[strings.ToUpper(segmentNo)] = true
+}
+
+// AddCancelVoid records the C0501 or C0701 rows returned for segmentNo on
+// date (YYYYMMDD)
+func (m *MemoryInvoiceSource) AddCancelVoid(messageType MessageType, segmentNo, date string, rows []CancelVoidRow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cancelVoids[memoryKey(messageType, segmentNo, date)] = rows
+	m.segments[strings.ToUpper(segmentNo)] = true
+}
+
+// Open starts a session for segmentNo. Segments without any recorded rows
+// are unknown.
+func (m *MemoryInvoiceSource) Open(segmentNo string) (InvoiceSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.segments[strings.ToUpper(segmentNo)] {
+		return nil, &models.UnknownSegmentError{Segment: segmentNo}
+	}
+	return &memorySession{source: m, segmentNo: segmentNo}, nil
+}
+
+// Segments lists the segments with recorded rows
+func (m *MemoryInvoiceSource) Segments() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	segments := make([]string, 0, len(m.segments))
+	for segment := range m.segments {
+		segments = append(segments, segment)
+	}
+	sort.Strings(segments)
+	return segments
+}
+
+func memoryKey(messageType MessageType, segmentNo, date string) string {
+	return string(messageType) + "/" + strings.ToUpper(segmentNo) + "/" + date
+}
+
+type memorySession struct {
+	source      *MemoryInvoiceSource
+	segmentNo   string
+	rows        []InvoiceRow
+	cancelVoids []CancelVoidRow
+}
+
+// PrepareC0401 selects the recorded rows for the date
+func (m *memorySession) PrepareC0401(invoiceDate time.Time) error {
+	m.source.mu.RLock()
+	defer m.source.mu.RUnlock()
+	m.rows = m.source.rows[memoryKey(MessageC0401, m.segmentNo, invoiceDate.Format("20060102"))]
+	return nil
+}
+
+// C0401Rows yields the selected rows
+func (m *memorySession) C0401Rows() iter.Seq2[InvoiceRow, error] {
+	return func(yield func(InvoiceRow, error) bool) {
+		for _, row := range m.rows {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// PrepareC0501 selects the recorded cancellations for the date
+func (m *memorySession) PrepareC0501(cancelDate time.Time) error {
+	return m.prepareCancelVoid(MessageC0501, cancelDate)
+}
+
+// C0501Rows yields the selected cancellations
+func (m *memorySession) C0501Rows() iter.Seq2[CancelVoidRow, error] {
+	return m.cancelVoidRows()
+}
+
+// PrepareC0701 selects the recorded voids for the date
+func (m *memorySession) PrepareC0701(voidDate time.Time) error {
+	return m.prepareCancelVoid(MessageC0701, voidDate)
+}
+
+// C0701Rows yields the selected voids
+func (m *memorySession) C0701Rows() iter.Seq2[CancelVoidRow, error] {
+	return m.cancelVoidRows()
+}
+
+func (m *memorySession) prepareCancelVoid(messageType MessageType, date time.Time) error {
+	m.source.mu.RLock()
+	defer m.source.mu.RUnlock()
+	m.cancelVoids = m.source.cancelVoids[memoryKey(messageType, m.segmentNo, date.Format("20060102"))]
+	return nil
+}
+
+func (m *memorySession) cancelVoidRows() iter.Seq2[CancelVoidRow, error] {
+	return func(yield func(CancelVoidRow, error) bool) {
+		for _, row := range m.cancelVoids {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (m *memorySession) Close() error {
+	return nil
+}
+
+// fixtureRow is the JSON form of an InvoiceRow. Keys follow the C0401 CSV
+// header; a missing key or null is a database NULL. Amounts may be JSON
+// numbers or strings.
+type fixtureRow struct {
+	InvoiceNumber        *string            `json:"InvoiceNumber"`
+	InvoiceDate          *string            `json:"InvoiceDate"`
+	InvoiceTime          *string            `json:"InvoiceTime"`
+	BuyerIdentifier      *string            `json:"BuyerIdentifier"`
+	BuyerName            *string            `json:"BuyerName"`
+	BuyerAddress         *string            `json:"BuyerAddress"`
+	BuyerTelephoneNumber *string            `json:"BuyerTelephoneNumber"`
+	BuyerEmailAddress    *string            `json:"BuyerEmailAddress"`
+	SalesAmount          models.NullDecimal `json:"SalesAmount"`
+	FreeTaxSalesAmount   models.NullDecimal `json:"FreeTaxSalesAmount"`
+	ZeroTaxSalesAmount   models.NullDecimal `json:"ZeroTaxSalesAmount"`
+	TaxType              *string            `json:"TaxType"`
+	TaxRate              models.NullDecimal `json:"TaxRate"`
+	TaxAmount            models.NullDecimal `json:"TaxAmount"`
+	TotalAmount          models.NullDecimal `json:"TotalAmount"`
+	PrintMark            *string            `json:"PrintMark"`
+	RandomNumber         *string            `json:"RandomNumber"`
+	MainRemark           *string            `json:"MainRemark"`
+	CarrierType          *string            `json:"CarrierType"`
+	CarrierID1           *string            `json:"CarrierId1"`
+	CarrierID2           *string            `json:"CarrierId2"`
+	NPOBAN               *string            `json:"NPOBAN"`
+	LineNo               int                `json:"LineNo"`
+	Description          *string            `json:"Description"`
+	Quantity             models.NullDecimal `json:"Quantity"`
+	UnitPrice            models.NullDecimal `json:"UnitPrice"`
+	Amount               models.NullDecimal `json:"Amount"`
+	DetailTaxType        *string            `json:"DetailTaxType"`
+	Remark               *string            `json:"Remark"`
+}
+
+// LoadFixtureFile reads a JSON array of recorded rows
+func LoadFixtureFile(path string) ([]InvoiceRow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading fixture %s: %w", path, err)
+	}
+
+	var fixtures []fixtureRow
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("decoding fixture %s: %w", path, err)
+	}
+
+	rows := make([]InvoiceRow, 0, len(fixtures))
+	for _, f := range fixtures {
+		rows = append(rows, InvoiceRow{
+			InvoiceNumber:        toNullString(f.InvoiceNumber),
+			InvoiceDate:          toNullString(f.InvoiceDate),
+			InvoiceTime:          toNullString(f.InvoiceTime),
+			BuyerIdentifier:      toNullString(f.BuyerIdentifier),
+			BuyerName:            toNullString(f.BuyerName),
+			BuyerAddress:         toNullString(f.BuyerAddress),
+			BuyerTelephoneNumber: toNullString(f.BuyerTelephoneNumber),
+			BuyerEmailAddress:    toNullString(f.BuyerEmailAddress),
+			SalesAmount:          f.SalesAmount,
+			FreeTaxSalesAmount:   f.FreeTaxSalesAmount,
+			ZeroTaxSalesAmount:   f.ZeroTaxSalesAmount,
+			TaxType:              toNullString(f.TaxType),
+			TaxRate:              f.TaxRate,
+			TaxAmount:            f.TaxAmount,
+			TotalAmount:          f.TotalAmount,
+			PrintMark:            toNullString(f.PrintMark),
+			RandomNumber:         toNullString(f.RandomNumber),
+			MainRemark:           toNullString(f.MainRemark),
+			CarrierType:          toNullString(f.CarrierType),
+			CarrierID1:           toNullString(f.CarrierID1),
+			CarrierID2:           toNullString(f.CarrierID2),
+			NPOBAN:               toNullString(f.NPOBAN),
+			LineNo:               f.LineNo,
+			Description:          toNullString(f.Description),
+			Quantity:             f.Quantity,
+			UnitPrice:            f.UnitPrice,
+			Amount:               f.Amount,
+			DetailTaxType:        toNullString(f.DetailTaxType),
+			Remark:               toNullString(f.Remark),
+		})
+	}
+
+	return rows, nil
+}
+
+// cancelVoidFixtureRow is the JSON form of a CancelVoidRow. Date, Time and
+// Reason accept both the C0501 (Cancel*) and the C0701 (Void*) key names.
+type cancelVoidFixtureRow struct {
+	InvoiceNumber           *string `json:"InvoiceNumber"`
+	InvoiceDate             *string `json:"InvoiceDate"`
+	BuyerID                 *string `json:"BuyerId"`
+	SellerID                *string `json:"SellerId"`
+	CancelDate              *string `json:"CancelDate"`
+	CancelTime              *string `json:"CancelTime"`
+	CancelReason            *string `json:"CancelReason"`
+	VoidDate                *string `json:"VoidDate"`
+	VoidTime                *string `json:"VoidTime"`
+	VoidReason              *string `json:"VoidReason"`
+	ReturnTaxDocumentNumber *string `json:"ReturnTaxDocumentNumber"`
+	Remark                  *string `json:"Remark"`
+}
+
+// LoadCancelVoidFixtureFile reads a JSON array of recorded C0501 or C0701 rows
+func LoadCancelVoidFixtureFile(path string) ([]CancelVoidRow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading fixture %s: %w", path, err)
+	}
+
+	var fixtures []cancelVoidFixtureRow
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("decoding fixture %s: %w", path, err)
+	}
+
+	rows := make([]CancelVoidRow, 0, len(fixtures))
+	for _, f := range fixtures {
+		rows = append(rows, CancelVoidRow{
+			InvoiceNumber:           toNullString(f.InvoiceNumber),
+			InvoiceDate:             toNullString(f.InvoiceDate),
+			BuyerID:                 toNullString(f.BuyerID),
+			SellerID:                toNullString(f.SellerID),
+			Date:                    toNullString(firstNonNil(f.CancelDate, f.VoidDate)),
+			Time:                    toNullString(firstNonNil(f.CancelTime, f.VoidTime)),
+			Reason:                  toNullString(firstNonNil(f.CancelReason, f.VoidReason)),
+			ReturnTaxDocumentNumber: toNullString(f.ReturnTaxDocumentNumber),
+			Remark:                  toNullString(f.Remark),
+		})
+	}
+
+	return rows, nil
+}
+
+func firstNonNil(values ...*string) *string {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func toNullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
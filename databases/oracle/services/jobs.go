@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobState is the lifecycle state of a generation job
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// ErrJobNotFound is returned for an unknown job ID
+var ErrJobNotFound = errors.New("job not found")
+
+// JobInProgressError is returned when a job for the same message type,
+// segment and date is already queued or running
+type JobInProgressError struct {
+	JobID string
+}
+
+func (e *JobInProgressError) Error() string {
+	return "a job for this message type, segment and date is already in progress: " + e.JobID
+}
+
+// Job is a snapshot of an asynchronous C0401/C0501/C0701 generation or of
+// a batch C0401 generation (Batch set)
+type Job struct {
+	ID          string               `json:"id"`
+	MessageType MessageType          `json:"message_type"`
+	SegmentNo   string               `json:"segment_no,omitempty"`
+	InvoiceDate string               `json:"invoice_date,omitempty"`
+	Format      OutputFormat         `json:"format"`
+	State       JobState             `json:"state"`
+	Result      *CSVGenerationResult `json:"result,omitempty"`
+	Batch       *BatchRequest        `json:"batch,omitempty"`
+	BatchReport *BatchReport         `json:"batch_report,omitempty"`
+	Error       string               `json:"error,omitempty"`
+	ErrorCode   string               `json:"error_code,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+	StartedAt   *time.Time           `json:"started_at,omitempty"`
+	FinishedAt  *time.Time           `json:"finished_at,omitempty"`
+
+	err error
+}
+
+// Err returns the error the job failed with
+func (j *Job) Err() error {
+	return j.err
+}
+
+// JobManager runs the generators in the background with a bounded number
+// of workers. Only one job per message type/segment/date is active at a
+// time, because they would write the same output.
+type JobManager struct {
+	svc       *InvoiceService
+	workers   chan struct{}
+	retention time.Duration
+
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	active map[string]string // message type/segment/date -> job ID
+	wg     sync.WaitGroup
+}
+
+// NewJobManager creates a JobManager running at most workers jobs at once.
+// Finished jobs are forgotten after retention.
+func NewJobManager(svc *InvoiceService, workers int, retention time.Duration) *JobManager {
+	if workers < 1 {
+		workers = 1
+	}
+	return &JobManager{
+		svc:       svc,
+		workers:   make(chan struct{}, workers),
+		retention: retention,
+		jobs:      make(map[string]*Job),
+		active:    make(map[string]string),
+	}
+}
+
+// Enqueue queues a generator run and returns the queued job
+func (m *JobManager) Enqueue(messageType MessageType, segmentNo, invoiceDate string, format OutputFormat) (Job, error) {
+	key := string(messageType) + "/" + strings.ToUpper(segmentNo) + "/" + invoiceDate
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruneLocked()
+	if id, ok := m.active[key]; ok {
+		return *m.jobs[id], &JobInProgressError{JobID: id}
+	}
+
+	job := &Job{
+		ID:          rand.Text(),
+		MessageType: messageType,
+		SegmentNo:   segmentNo,
+		InvoiceDate: invoiceDate,
+		Format:      format,
+		State:       JobQueued,
+		CreatedAt:   time.Now(),
+	}
+	m.jobs[job.ID] = job
+	m.active[key] = job.ID
+
+	m.wg.Add(1)
+	go m.run(job, key)
+
+	return *job, nil
+}
+
+// EnqueueBatch validates req and queues a batch C0401 generation. The batch
+// does not take a worker slot; it runs req.Concurrency segments at once and
+// claims each segment/date in turn, so an item whose single job is active
+// fails with JobInProgressError.
+func (m *JobManager) EnqueueBatch(req BatchRequest) (Job, error) {
+	segments, dates, err := m.svc.ResolveBatch(req)
+	if err != nil {
+		return Job{}, err
+	}
+	req.Segments = segments
+	req.From, req.To = dates[0], dates[len(dates)-1]
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruneLocked()
+	job := &Job{
+		ID:          rand.Text(),
+		MessageType: MessageC0401,
+		Format:      req.Format,
+		Batch:       &req,
+		State:       JobQueued,
+		CreatedAt:   time.Now(),
+	}
+	m.jobs[job.ID] = job
+
+	m.wg.Add(1)
+	go m.runBatch(job)
+
+	return *job, nil
+}
+
+// Get returns a snapshot of a job
+func (m *JobManager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+// Wait blocks until every queued and running job has finished
+func (m *JobManager) Wait() {
+	m.wg.Wait()
+}
+
+func (m *JobManager) run(job *Job, key string) {
+	defer m.wg.Done()
+
+	m.workers <- struct{}{}
+	defer func() { <-m.workers }()
+
+	m.withLock(func() {
+		now := time.Now()
+		job.State = JobRunning
+		job.StartedAt = &now
+	})
+
+	result, err := m.svc.Generate(job.MessageType, job.SegmentNo, job.InvoiceDate, job.Format)
+
+	m.withLock(func() {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Result = result
+		if err != nil {
+			job.State = JobFailed
+			job.Error = err.Error()
+			job.ErrorCode = AsError(err).Code
+			job.err = err
+		} else {
+			job.State = JobSucceeded
+		}
+		delete(m.active, key)
+	})
+}
+
+func (m *JobManager) runBatch(job *Job) {
+	defer m.wg.Done()
+
+	m.withLock(func() {
+		now := time.Now()
+		job.State = JobRunning
+		job.StartedAt = &now
+	})
+
+	reserve := func(segmentNo, invoiceDate string) (func(), error) {
+		key := string(MessageC0401) + "/" + strings.ToUpper(segmentNo) + "/" + invoiceDate
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if id, ok := m.active[key]; ok {
+			return nil, &JobInProgressError{JobID: id}
+		}
+		m.active[key] = job.ID
+		return func() { m.withLock(func() { delete(m.active, key) }) }, nil
+	}
+	report, err := m.svc.runBatchC0401(context.Background(), *job.Batch, reserve)
+
+	m.withLock(func() {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.BatchReport = report
+		switch {
+		case err != nil:
+			job.State = JobFailed
+			job.Error = err.Error()
+			job.ErrorCode = AsError(err).Code
+			job.err = err
+		case report.Failed > 0:
+			job.State = JobFailed
+			job.Error = fmt.Sprintf("%d of %d generations failed", report.Failed, report.Failed+report.Succeeded)
+			job.ErrorCode = "BATCH_PARTIAL_FAILURE"
+		default:
+			job.State = JobSucceeded
+		}
+	})
+}
+
+func (m *JobManager) withLock(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn()
+}
+
+// pruneLocked forgets finished jobs older than the retention period
+func (m *JobManager) pruneLocked() {
+	if m.retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-m.retention)
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(m.jobs, id)
+		}
+	}
+}
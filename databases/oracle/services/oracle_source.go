@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"oracle-demo/models"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// OracleReport is the stored procedure and query behind a C0501 or C0701
+// file. Procedure is called with the date after PK_ERP.P_SET_SEGMENT_NO on
+// the same session; Query then returns, in this order, the invoice number,
+// invoice date (YYYYMMDD), buyer and seller identifiers, cancel/void date
+// (YYYYMMDD) and time, reason, return tax document number and remark.
+type OracleReport struct {
+	Procedure string
+	Query     string
+}
+
+// procedureNamePattern is a possibly schema- or package-qualified PL/SQL
+// name; it is pasted into the anonymous block that calls it
+var procedureNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_$#]*(\.[A-Za-z][A-Za-z0-9_$#]*){0,2}$`)
+
+// OracleInvoiceSource reads invoice data from the ARGO ERP Oracle schema
+type OracleInvoiceSource struct {
+	conns *models.ConnectionManager
+	// reports holds the C0501/C0701 reports; a message type without one
+	// cannot be generated
+	reports map[MessageType]OracleReport
+}
+
+// NewOracleInvoiceSource creates a new OracleInvoiceSource using the
+// per-segment pools of conns
+func NewOracleInvoiceSource(conns *models.ConnectionManager) *OracleInvoiceSource {
+	return &OracleInvoiceSource{
+		conns:   conns,
+		reports: make(map[MessageType]OracleReport),
+	}
+}
+
+// SetReport configures the procedure and query of messageType (C0501 or
+// C0701). Call it before the source is used.
+func (o *OracleInvoiceSource) SetReport(messageType MessageType, report OracleReport) error {
+	if messageType != MessageC0501 && messageType != MessageC0701 {
+		return fmt.Errorf("no configurable report for %s", messageType)
+	}
+	if !procedureNamePattern.MatchString(report.Procedure) {
+		return fmt.Errorf("%s procedure: invalid name %q", messageType, report.Procedure)
+	}
+	if strings.TrimSpace(report.Query) == "" {
+		return fmt.Errorf("%s query is empty", messageType)
+	}
+	o.reports[messageType] = report
+	return nil
+}
+
+// Segments lists the segments registered with the connection manager
+func (o *OracleInvoiceSource) Segments() []string {
+	return o.conns.Segments()
+}
+
+// oracleSession pins a single connection, because P_SET_SEGMENT_NO sets
+// session state and mr_global_temp is a session-scoped temporary table
+type oracleSession struct {
+	ctx       context.Context
+	conn      *sql.Conn
+	release   func()
+	segmentNo string
+	reports   map[MessageType]OracleReport
+}
+
+// Open reserves one connection from the segment's pool. The pool stays
+// open until the session is closed, even across a segment reload.
+func (o *OracleInvoiceSource) Open(segmentNo string) (InvoiceSession, error) {
+	db, release, err := o.conns.Acquire(segmentNo)
+	var unknownSegment *models.UnknownSegmentError
+	if errors.As(err, &unknownSegment) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, upstreamError("getting database connection", err)
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		release()
+		return nil, upstreamError("reserving database connection", err)
+	}
+
+	return &oracleSession{
+		ctx:       ctx,
+		conn:      conn,
+		release:   release,
+		segmentNo: strings.ToUpper(segmentNo),
+		reports:   o.reports,
+	}, nil
+}
+
+// Supports reports whether messageType can be generated: C0401 always,
+// C0501 and C0701 once SetReport configured them
+func (o *OracleInvoiceSource) Supports(messageType MessageType) bool {
+	if messageType == MessageC0401 {
+		return true
+	}
+	_, ok := o.reports[messageType]
+	return ok
+}
+
+// PrepareC0401 calls the stored procedures that fill argoerp.mr_global_temp
+func (o *oracleSession) PrepareC0401(invoiceDate time.Time) error {
+	return o.prepare("ARGOERP.P_RPT_MRIF004", invoiceDate)
+}
+
+// PrepareC0501 calls the configured C0501 procedure for the cancellations
+// made on cancelDate
+func (o *oracleSession) PrepareC0501(cancelDate time.Time) error {
+	return o.prepareReport(MessageC0501, cancelDate)
+}
+
+// PrepareC0701 calls the configured C0701 procedure for the voids made on
+// voidDate
+func (o *oracleSession) PrepareC0701(voidDate time.Time) error {
+	return o.prepareReport(MessageC0701, voidDate)
+}
+
+// prepareReport calls the procedure configured for messageType
+func (o *oracleSession) prepareReport(messageType MessageType, date time.Time) error {
+	report, ok := o.reports[messageType]
+	if !ok {
+		return reportNotConfiguredError(messageType)
+	}
+	return o.prepare(report.Procedure, date)
+}
+
+// prepare sets the session segment and calls the report procedure for date
+func (o *oracleSession) prepare(procedure string, date time.Time) error {
+	// Call PK_ERP.P_SET_SEGMENT_NO
+	_, err := o.conn.ExecContext(o.ctx, "BEGIN PK_ERP.P_SET_SEGMENT_NO(:1); END;", o.segmentNo)
+	if err != nil {
+		return upstreamError("calling PK_ERP.P_SET_SEGMENT_NO", err)
+	}
+
+	_, err = o.conn.ExecContext(o.ctx, "BEGIN "+procedure+"(:1); END;", date)
+	if err != nil {
+		return upstreamError("calling "+procedure, err)
+	}
+
+	return nil
+}
+
+// C0401Rows executes the main data retrieval query and yields rows one at
+// a time straight from sql.Rows. The rows are closed when iteration stops.
+// Using TRIM with proper NULL handling for Oracle; amounts are selected as
+// plain NUMBER so they scan into exact decimals.
+func (o *oracleSession) C0401Rows() iter.Seq2[InvoiceRow, error] {
+	return func(yield func(InvoiceRow, error) bool) {
+		query := `
+			SELECT TRIM(var_attr01) InvoiceNumber,
+				TRIM(DECODE(num_attr08,1,TO_CHAR(date_attr01,'YYYYMMDD'),'')) InvoiceDate,
+				TRIM(DECODE(num_attr08,1,var_attr02,'')) InvoiceTime,        
+				TRIM(DECODE(num_attr08,1,var_attr03,'')) BuyerIdentifier,    
+				TRIM(DECODE(num_attr08,1,var_attr04,'')) BuyerName,          
+				TRIM(DECODE(num_attr08,1,var_attr05,'')) BuyerAddress,       
+				TRIM(DECODE(num_attr08,1,var_attr06,'')) BuyerTelephoneNumber,
+				TRIM(DECODE(num_attr08,1,var_attr07,'')) BuyerEmailAddress,  
+				DECODE(num_attr08,1,num_attr01,NULL) SalesAmount,
+				DECODE(num_attr08,1,num_attr02,NULL) FreeTaxSalesAmount,
+				DECODE(num_attr08,1,num_attr03,NULL) ZeroTaxSalesAmount,
+				TRIM(DECODE(num_attr08,1,var_attr08,'')) TaxType,            
+				DECODE(num_attr08,1,num_attr04,NULL) TaxRate,
+				DECODE(num_attr08,1,num_attr05,NULL) TaxAmount,
+				DECODE(num_attr08,1,num_attr06,NULL) TotalAmount,
+				TRIM(DECODE(num_attr08,1,var_attr09,'')) PrintMark,   
+				TRIM(TO_CHAR(DECODE(num_attr08,1,num_attr07,NULL))) RandomNumber,
+				TRIM(DECODE(num_attr08,1,var_attr10,'')) MainRemark,  
+				TRIM(DECODE(num_attr08,1,var_attr11,'')) CarrierType, 
+				TRIM(DECODE(num_attr08,1,var_attr12,'')) CarrierId1,  
+				TRIM(DECODE(num_attr08,1,var_attr13,'')) CarrierId2,  
+				TRIM(DECODE(num_attr08,1,var_attr14,'')) NPOBAN,      
+				num_attr08 line_no,    
+				TRIM(var_attr15) Description,
+				num_attr09 Quantity,
+				num_attr10 UnitPrice,
+				num_attr11 Amount,
+				TRIM(var_attr08) DetailTaxType,     
+				TRIM(var_attr16) Remark      
+			FROM argoerp.mr_global_temp a
+			WHERE a.pid = 'MRIF004'
+			ORDER BY a.var_attr01, a.num_attr08`
+
+		rows, err := o.conn.QueryContext(o.ctx, query)
+		if err != nil {
+			yield(InvoiceRow{}, upstreamError("executing query", err))
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var row InvoiceRow
+			err := rows.Scan(
+				&row.InvoiceNumber,
+				&row.InvoiceDate,
+				&row.InvoiceTime,
+				&row.BuyerIdentifier,
+				&row.BuyerName,
+				&row.BuyerAddress,
+				&row.BuyerTelephoneNumber,
+				&row.BuyerEmailAddress,
+				&row.SalesAmount,
+				&row.FreeTaxSalesAmount,
+				&row.ZeroTaxSalesAmount,
+				&row.TaxType,
+				&row.TaxRate,
+				&row.TaxAmount,
+				&row.TotalAmount,
+				&row.PrintMark,
+				&row.RandomNumber,
+				&row.MainRemark,
+				&row.CarrierType,
+				&row.CarrierID1,
+				&row.CarrierID2,
+				&row.NPOBAN,
+				&row.LineNo,
+				&row.Description,
+				&row.Quantity,
+				&row.UnitPrice,
+				&row.Amount,
+				&row.DetailTaxType,
+				&row.Remark,
+			)
+			if err != nil {
+				yield(InvoiceRow{}, upstreamError("scanning row", err))
+				return
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			yield(InvoiceRow{}, upstreamError("iterating rows", err))
+		}
+	}
+}
+
+// C0501Rows yields the cancellations prepared by PrepareC0501
+func (o *oracleSession) C0501Rows() iter.Seq2[CancelVoidRow, error] {
+	return o.cancelVoidRows(o.reports[MessageC0501].Query)
+}
+
+// C0701Rows yields the voids prepared by PrepareC0701
+func (o *oracleSession) C0701Rows() iter.Seq2[CancelVoidRow, error] {
+	return o.cancelVoidRows(o.reports[MessageC0701].Query)
+}
+
+// cancelVoidRows runs a C0501/C0701 query and yields its rows one at a time
+func (o *oracleSession) cancelVoidRows(query string) iter.Seq2[CancelVoidRow, error] {
+	return func(yield func(CancelVoidRow, error) bool) {
+		rows, err := o.conn.QueryContext(o.ctx, query)
+		if err != nil {
+			yield(CancelVoidRow{}, upstreamError("executing query", err))
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var row CancelVoidRow
+			err := rows.Scan(
+				&row.InvoiceNumber,
+				&row.InvoiceDate,
+				&row.BuyerID,
+				&row.SellerID,
+				&row.Date,
+				&row.Time,
+				&row.Reason,
+				&row.ReturnTaxDocumentNumber,
+				&row.Remark,
+			)
+			if err != nil {
+				yield(CancelVoidRow{}, upstreamError("scanning row", err))
+				return
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			yield(CancelVoidRow{}, upstreamError("iterating rows", err))
+		}
+	}
+}
+
+// Close returns the connection to the pool and releases the pool
+func (o *oracleSession) Close() error {
+	defer o.release()
+	return o.conn.Close()
+}
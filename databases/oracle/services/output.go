@@ -0,0 +1,176 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RegeneratePolicy decides what happens when an output for the same
+// segment/date already exists
+type RegeneratePolicy string
+
+const (
+	// RegenerateVersion renames the previous output to <name>.v<N> first
+	RegenerateVersion RegeneratePolicy = "version"
+	// RegenerateRefuse fails the generation with ErrAlreadyGenerated
+	RegenerateRefuse RegeneratePolicy = "refuse"
+)
+
+// ErrAlreadyGenerated is returned under RegenerateRefuse when the output
+// already exists
+var ErrAlreadyGenerated = errors.New("output already generated")
+
+// ManifestFile is the checksum of one file of a multi-file output
+type ManifestFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest is written next to every output as <output>.manifest.json.
+// For an XML directory SHA256 covers the sha256sum-style listing of Files.
+type Manifest struct {
+	MessageType  MessageType    `json:"message_type"`
+	SegmentNo    string         `json:"segment_no"`
+	InvoiceDate  string         `json:"invoice_date"`
+	Format       OutputFormat   `json:"format"`
+	FileName     string         `json:"file_name"`
+	RowCount     int            `json:"row_count"`
+	InvoiceCount int            `json:"invoice_count"`
+	SHA256       string         `json:"sha256"`
+	Files        []ManifestFile `json:"files,omitempty"`
+	GeneratedAt  time.Time      `json:"generated_at"`
+}
+
+// manifestPath returns the sidecar manifest of an output
+func manifestPath(outputPath string) string {
+	return outputPath + ".manifest.json"
+}
+
+// SetRegeneratePolicy sets how an existing output is handled on regeneration
+func (s *InvoiceService) SetRegeneratePolicy(policy RegeneratePolicy) {
+	s.regeneratePolicy = policy
+}
+
+// checkRegenerate fails early under RegenerateRefuse so that no stored
+// procedure runs for an output that will not be written
+func (s *InvoiceService) checkRegenerate(outputPath string) error {
+	if s.regeneratePolicy != RegenerateRefuse {
+		return nil
+	}
+	if _, err := os.Stat(outputPath); err == nil {
+		return fmt.Errorf("%w: %s", ErrAlreadyGenerated, filepath.Base(outputPath))
+	}
+	return nil
+}
+
+// installOutput moves a fully written and synced tmpPath to outputPath
+// together with its manifest, applying the regenerate policy to any
+// previous output
+func (s *InvoiceService) installOutput(tmpPath, outputPath string, manifest *Manifest) error {
+	manifest.FileName = filepath.Base(outputPath)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+	tmpManifest := manifestPath(tmpPath)
+	if err := writeFileSync(tmpManifest, data); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	if _, err := os.Stat(outputPath); err == nil {
+		if s.regeneratePolicy == RegenerateRefuse {
+			os.Remove(tmpManifest)
+			return fmt.Errorf("%w: %s", ErrAlreadyGenerated, filepath.Base(outputPath))
+		}
+		if err := versionOutput(outputPath); err != nil {
+			os.Remove(tmpManifest)
+			return fmt.Errorf("versioning previous output: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		os.Remove(tmpManifest)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		os.Remove(tmpManifest)
+		return fmt.Errorf("renaming output: %w", err)
+	}
+	if err := os.Rename(tmpManifest, manifestPath(outputPath)); err != nil {
+		return fmt.Errorf("renaming manifest: %w", err)
+	}
+
+	return syncDir(filepath.Dir(outputPath))
+}
+
+// versionOutput renames outputPath (and its manifest) to the first free
+// <name>.v<N>[.csv]
+func versionOutput(outputPath string) error {
+	dir, name := filepath.Split(outputPath)
+	ext := ""
+	if strings.HasSuffix(name, ".csv") {
+		ext = ".csv"
+	}
+	base := strings.TrimSuffix(name, ext)
+
+	for n := 1; ; n++ {
+		versioned := filepath.Join(dir, fmt.Sprintf("%s.v%d%s", base, n, ext))
+		if _, err := os.Stat(versioned); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		// archived versions keep their number too
+		if _, err := os.Stat(versioned + ".gz"); err == nil {
+			continue
+		}
+		if _, err := os.Stat(versioned + ".tar.gz"); err == nil {
+			continue
+		}
+
+		if err := os.Rename(outputPath, versioned); err != nil {
+			return err
+		}
+		if err := os.Rename(manifestPath(outputPath), manifestPath(versioned)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+}
+
+// writeFileSync writes data to path and fsyncs it
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir fsyncs a directory so that renames inside it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("syncing %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrRareCharacters is returned under RareCharReject when a free-text
+// column contains characters the government platform rejects
+var ErrRareCharacters = errors.New("rare characters in invoice text")
+
+// RareCharMode selects how rare characters in free-text columns are handled
+type RareCharMode string
+
+const (
+	// RareCharPass writes the text unchanged and only reports the hits
+	RareCharPass RareCharMode = "pass"
+	// RareCharReplace substitutes every hit via Mapping, falling back to Substitute
+	RareCharReplace RareCharMode = "replace"
+	// RareCharReject fails the generation after reporting every hit
+	RareCharReject RareCharMode = "reject"
+)
+
+// RareCharPolicy is applied to BuyerName, BuyerAddress, Description,
+// MainRemark and Remark of C0401 and to the reason and Remark of C0501 and
+// C0701. The zero value passes text through.
+type RareCharPolicy struct {
+	Mode       RareCharMode
+	Substitute string
+	Mapping    map[rune]string
+}
+
+// RareCharHit records one rare character found in a row
+type RareCharHit struct {
+	InvoiceNumber string `json:"invoice_number"`
+	LineNo        int    `json:"line_no"`
+	Field         string `json:"field"`
+	Char          string `json:"char"`
+	CodePoint     string `json:"code_point"`
+	Replacement   string `json:"replacement,omitempty"`
+}
+
+// isRareRune reports CJK Extension B and later (planes 2 and 3), which
+// Big5-based systems on the platform cannot represent
+func isRareRune(r rune) bool {
+	return r >= 0x20000 && r <= 0x3FFFF
+}
+
+// SetRareCharPolicy sets the handling of rare characters in free-text columns
+func (s *InvoiceService) SetRareCharPolicy(policy RareCharPolicy) {
+	s.rareCharPolicy = policy
+}
+
+// rareCharField is a free-text column checked by the rare character policy
+type rareCharField struct {
+	name  string
+	value *sql.NullString
+}
+
+// applyRareCharPolicy checks the free-text columns of row, rewriting them
+// under RareCharReplace, and returns every hit
+func (s *InvoiceService) applyRareCharPolicy(row *InvoiceRow) []RareCharHit {
+	return s.applyRareCharFields(row.InvoiceNumber.String, row.LineNo, []rareCharField{
+		{"BuyerName", &row.BuyerName},
+		{"BuyerAddress", &row.BuyerAddress},
+		{"Description", &row.Description},
+		{"MainRemark", &row.MainRemark},
+		{"Remark", &row.Remark},
+	})
+}
+
+// applyRareCharFields applies the policy to fields of one row
+func (s *InvoiceService) applyRareCharFields(invoiceNumber string, lineNo int, fields []rareCharField) []RareCharHit {
+	var hits []RareCharHit
+	for _, f := range fields {
+		if !f.value.Valid {
+			continue
+		}
+		text, fieldHits := s.rareCharPolicy.apply(f.value.String)
+		for i := range fieldHits {
+			fieldHits[i].InvoiceNumber = invoiceNumber
+			fieldHits[i].LineNo = lineNo
+			fieldHits[i].Field = f.name
+		}
+		hits = append(hits, fieldHits...)
+		f.value.String = text
+	}
+	return hits
+}
+
+// apply scans text and, under RareCharReplace, returns it with every rare
+// character substituted
+func (p RareCharPolicy) apply(text string) (string, []RareCharHit) {
+	var hits []RareCharHit
+	var b strings.Builder
+	for _, r := range text {
+		if !isRareRune(r) {
+			b.WriteRune(r)
+			continue
+		}
+
+		hit := RareCharHit{
+			Char:      string(r),
+			CodePoint: fmt.Sprintf("U+%X", r),
+		}
+		if p.Mode == RareCharReplace {
+			replacement, ok := p.Mapping[r]
+			if !ok {
+				replacement = p.Substitute
+			}
+			hit.Replacement = replacement
+			b.WriteString(replacement)
+		} else {
+			b.WriteRune(r)
+		}
+		hits = append(hits, hit)
+	}
+
+	if len(hits) == 0 {
+		return text, nil
+	}
+	return b.String(), hits
+}
+
+// LoadRareCharMapping reads a JSON object mapping rare characters to their
+// replacements. Keys are either the character itself or "U+XXXXX".
+func LoadRareCharMapping(path string) (map[rune]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rare character mapping: %w", err)
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("decoding rare character mapping: %w", err)
+	}
+
+	mapping := make(map[rune]string, len(raw))
+	for key, replacement := range raw {
+		var r rune
+		if code, ok := strings.CutPrefix(strings.ToUpper(key), "U+"); ok {
+			n, err := strconv.ParseInt(code, 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("rare character mapping key %q: %w", key, err)
+			}
+			r = rune(n)
+		} else {
+			var size int
+			r, size = utf8.DecodeRuneInString(key)
+			if size != len(key) || r == utf8.RuneError {
+				return nil, fmt.Errorf("rare character mapping key %q is not a single character", key)
+			}
+		}
+		mapping[r] = replacement
+	}
+
+	return mapping, nil
+}
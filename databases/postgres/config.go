@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Config is the service configuration. Every setting comes from, in order
+// of precedence, its command-line flag, its environment variable, the .env
+// file and the built-in default.
+type Config struct {
+	ListenAddr string
+	DSN        string
+	LogLevel   slog.Level
+	// AutoMigrate applies pending schema migrations at startup
+	AutoMigrate bool
+	// Args are the arguments left after the flags, such as "migrate up"
+	Args []string
+
+	QueryTimeout    time.Duration
+	PingTimeout     time.Duration
+	ShutdownTimeout time.Duration
+
+	ReadHeaderTimeout time.Duration
+	// WriteTimeout is 0 by default because /actors/export streams for as
+	// long as the client reads
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// loadConfig parses args (without the program name) and the environment
+func loadConfig(args []string) (*Config, error) {
+	var cfg Config
+	var logLevel string
+	fs := flag.NewFlagSet("postgres-demo", flag.ContinueOnError)
+	envFile := fs.String("env-file", ".env", "file with KEY=value lines loaded into the environment; variables already set win")
+
+	// env maps each flag to the environment variable it falls back to
+	env := map[string]string{}
+	stringVar := func(p *string, name, envName, def, usage string) {
+		fs.StringVar(p, name, def, usage+" ($"+envName+")")
+		env[name] = envName
+	}
+	durationVar := func(p *time.Duration, name, envName string, def time.Duration, usage string) {
+		fs.DurationVar(p, name, def, usage+" ($"+envName+")")
+		env[name] = envName
+	}
+	boolVar := func(p *bool, name, envName string, def bool, usage string) {
+		fs.BoolVar(p, name, def, usage+" ($"+envName+")")
+		env[name] = envName
+	}
+	intVar := func(p *int, name, envName string, def int, usage string) {
+		fs.IntVar(p, name, def, usage+" ($"+envName+")")
+		env[name] = envName
+	}
+
+	stringVar(&cfg.ListenAddr, "listen", "LISTEN_ADDR", "0.0.0.0:8080", "HTTP listen address")
+	stringVar(&cfg.DSN, "dsn", "POSTGRES_DSN", "", "Postgres connection string")
+	stringVar(&logLevel, "log-level", "LOG_LEVEL", "info", "debug, info, warn or error")
+	boolVar(&cfg.AutoMigrate, "auto-migrate", "AUTO_MIGRATE", false, "apply pending schema migrations at startup")
+	durationVar(&cfg.QueryTimeout, "query-timeout", "ACTOR_QUERY_TIMEOUT", 5*time.Second, "limit of every actor query, 0 for none")
+	durationVar(&cfg.PingTimeout, "ping-timeout", "POSTGRES_PING_TIMEOUT", 2*time.Second, "limit of the startup and /readyz database ping")
+	durationVar(&cfg.ShutdownTimeout, "shutdown-timeout", "SHUTDOWN_TIMEOUT", 15*time.Second, "time given to in-flight requests on SIGINT/SIGTERM")
+	durationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", "HTTP_READ_HEADER_TIMEOUT", 10*time.Second, "limit for reading request headers")
+	durationVar(&cfg.WriteTimeout, "write-timeout", "HTTP_WRITE_TIMEOUT", 0, "limit for writing a response, 0 for none")
+	durationVar(&cfg.IdleTimeout, "idle-timeout", "HTTP_IDLE_TIMEOUT", 2*time.Minute, "keep-alive idle limit")
+	intVar(&cfg.MaxOpenConns, "max-open-conns", "POSTGRES_MAX_OPEN_CONNS", 20, "pool size limit")
+	intVar(&cfg.MaxIdleConns, "max-idle-conns", "POSTGRES_MAX_IDLE_CONNS", 10, "idle connections kept in the pool")
+	durationVar(&cfg.ConnMaxLifetime, "conn-max-lifetime", "POSTGRES_CONN_MAX_LIFETIME", 30*time.Minute, "connection lifetime limit")
+	durationVar(&cfg.ConnMaxIdleTime, "conn-max-idle-time", "POSTGRES_CONN_MAX_IDLE_TIME", 5*time.Minute, "connection idle limit")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// godotenv does not override variables that are already set, which
+	// puts the real environment above the file
+	if err := godotenv.Load(*envFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading %s: %w", *envFile, err)
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, envName := range env {
+		value, ok := os.LookupEnv(envName)
+		if set[name] || !ok || value == "" {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", envName, err)
+		}
+	}
+
+	cfg.Args = fs.Args()
+	if cfg.DSN == "" {
+		return nil, errors.New("POSTGRES_DSN (-dsn) is not set")
+	}
+	if err := cfg.LogLevel.UnmarshalText([]byte(strings.ToUpper(logLevel))); err != nil {
+		return nil, fmt.Errorf("LOG_LEVEL (-log-level): %w", err)
+	}
+	return &cfg, nil
+}
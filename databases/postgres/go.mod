@@ -35,4 +35,7 @@ require (
 	golang.org/x/text v0.27.0 // indirect
 	google.golang.org/protobuf v1.36.6 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
+	migrate v0.0.0
 )
+
+replace migrate => ../migrate
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"postgres-demo/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetActorHandler serves GET /actors/:id
+//
+// Responds 200 with the actor and its ETag, 304 when If-None-Match matches
+// or 404.
+func (h *ActorHandler) GetActorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := actorID(c)
+		if !ok {
+			return
+		}
+		actor, err := h.svc.GetActor(c.Request.Context(), id)
+		if err != nil {
+			respondActorError(c, err)
+			return
+		}
+		c.Header("ETag", actor.ETag())
+		if c.GetHeader("If-None-Match") == actor.ETag() {
+			c.Status(304)
+			return
+		}
+		c.JSON(200, actor)
+	}
+}
+
+// CreateActorHandler serves POST /actors
+//
+// Body: {"first_name": "...", "last_name": "..."}, each 1 to 45 characters.
+// Responds 201 with the actor, its Location and ETag, or 400.
+func (h *ActorHandler) CreateActorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in services.ActorInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		actor, err := h.svc.CreateActor(c.Request.Context(), in)
+		if err != nil {
+			respondActorError(c, err)
+			return
+		}
+		c.Header("Location", "/actors/"+strconv.Itoa(actor.ActorID))
+		c.Header("ETag", actor.ETag())
+		c.JSON(201, actor)
+	}
+}
+
+// UpdateActorHandler serves PUT /actors/:id with the body of
+// CreateActorHandler
+//
+// With If-Match the update only happens when it matches the current ETag.
+// Responds 200 with the updated actor and its ETag, 400, 404 or 412.
+func (h *ActorHandler) UpdateActorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := actorID(c)
+		if !ok {
+			return
+		}
+		var in services.ActorInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		actor, err := h.svc.UpdateActor(c.Request.Context(), id, in, c.GetHeader("If-Match"))
+		if err != nil {
+			respondActorError(c, err)
+			return
+		}
+		c.Header("ETag", actor.ETag())
+		c.JSON(200, actor)
+	}
+}
+
+// PatchActorHandler serves PATCH /actors/:id; fields missing from the body
+// are kept. Otherwise it behaves like UpdateActorHandler.
+func (h *ActorHandler) PatchActorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := actorID(c)
+		if !ok {
+			return
+		}
+		var patch services.ActorPatch
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		actor, err := h.svc.PatchActor(c.Request.Context(), id, patch, c.GetHeader("If-Match"))
+		if err != nil {
+			respondActorError(c, err)
+			return
+		}
+		c.Header("ETag", actor.ETag())
+		c.JSON(200, actor)
+	}
+}
+
+// DeleteActorHandler serves DELETE /actors/:id
+//
+// If-Match works as in UpdateActorHandler. Responds 204, 404, 409 when films
+// still reference the actor, or 412.
+func (h *ActorHandler) DeleteActorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := actorID(c)
+		if !ok {
+			return
+		}
+		if err := h.svc.DeleteActor(c.Request.Context(), id, c.GetHeader("If-Match")); err != nil {
+			respondActorError(c, err)
+			return
+		}
+		c.Status(204)
+	}
+}
+
+// actorID parses :id, responding 400 when it is not a positive integer
+func actorID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		c.JSON(400, gin.H{"error": "id must be a positive integer"})
+		return 0, false
+	}
+	return id, true
+}
+
+// statusClientClosedRequest is the nginx convention for a client that went
+// away before the response
+const statusClientClosedRequest = 499
+
+// respondActorError maps the ActorService errors to their status
+func respondActorError(c *gin.Context, err error) {
+	var notFound *services.ActorNotFoundError
+	var invalid *services.InvalidParamError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// Nobody reads the body; the status shows up in the access log
+		c.AbortWithStatus(statusClientClosedRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.Warn("database query timed out", "err", err)
+		c.JSON(504, gin.H{"error": "database query timed out"})
+	case errors.As(err, &invalid):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.As(err, &notFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPreconditionFailed):
+		c.JSON(412, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrActorInUse):
+		c.JSON(409, gin.H{"error": err.Error()})
+	default:
+		slog.Error("database error", "err", err)
+		c.String(500, "Internal Server Error")
+	}
+}
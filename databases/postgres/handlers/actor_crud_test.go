@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"postgres-demo/services"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newActorRouter serves the actor CRUD routes as main does, over f
+func newActorRouter(t *testing.T, f *fakeActorDB) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := f.open()
+	t.Cleanup(func() { db.Close() })
+
+	h := NewActorHandler(services.NewActorService(db, time.Second))
+	r := gin.New()
+	r.POST("/actors", h.CreateActorHandler())
+	r.GET("/actors/:id", h.GetActorHandler())
+	r.PUT("/actors/:id", h.UpdateActorHandler())
+	r.PATCH("/actors/:id", h.PatchActorHandler())
+	r.DELETE("/actors/:id", h.DeleteActorHandler())
+	return r
+}
+
+// serve runs one request; header is applied as key, value pairs
+func serve(r http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func decodeActor(t *testing.T, w *httptest.ResponseRecorder) services.Actor {
+	t.Helper()
+	var actor services.Actor
+	if err := json.Unmarshal(w.Body.Bytes(), &actor); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+	return actor
+}
+
+func actorPath(id int64) string {
+	return "/actors/" + strconv.FormatInt(id, 10)
+}
+
+func TestGetActor(t *testing.T) {
+	f := newFakeActorDB()
+	id := f.add("PENELOPE", "GUINESS")
+	r := newActorRouter(t, f)
+
+	w := serve(r, "GET", actorPath(id), "")
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	actor := decodeActor(t, w)
+	if actor.FirstName != "PENELOPE" || actor.LastName != "GUINESS" {
+		t.Errorf("actor = %+v", actor)
+	}
+	etag := w.Header().Get("ETag")
+	if etag != actor.ETag() {
+		t.Errorf("ETag = %s, want %s", etag, actor.ETag())
+	}
+
+	if w := serve(r, "GET", actorPath(id), "", "If-None-Match", etag); w.Code != 304 {
+		t.Errorf("If-None-Match status = %d, want 304", w.Code)
+	}
+	if w := serve(r, "GET", "/actors/99", ""); w.Code != 404 {
+		t.Errorf("missing actor status = %d, want 404", w.Code)
+	}
+	if w := serve(r, "GET", "/actors/abc", ""); w.Code != 400 {
+		t.Errorf("bad id status = %d, want 400", w.Code)
+	}
+}
+
+func TestCreateActor(t *testing.T) {
+	f := newFakeActorDB()
+	r := newActorRouter(t, f)
+
+	w := serve(r, "POST", "/actors", `{"first_name": "NICK", "last_name": "WAHLBERG"}`)
+	if w.Code != 201 {
+		t.Fatalf("status = %d, want 201: %s", w.Code, w.Body)
+	}
+	actor := decodeActor(t, w)
+	if got := w.Header().Get("Location"); got != actorPath(int64(actor.ActorID)) {
+		t.Errorf("Location = %s", got)
+	}
+	if got := w.Header().Get("ETag"); got != actor.ETag() {
+		t.Errorf("ETag = %s, want %s", got, actor.ETag())
+	}
+	if stored, ok := f.get(int64(actor.ActorID)); !ok || stored.firstName != "NICK" {
+		t.Errorf("stored actor = %+v, %v", stored, ok)
+	}
+
+	for _, body := range []string{
+		`{"first_name": "NICK"}`,
+		`{"first_name": "NICK", "last_name": "` + strings.Repeat("X", 46) + `"}`,
+		`not json`,
+	} {
+		if w := serve(r, "POST", "/actors", body); w.Code != 400 {
+			t.Errorf("body %s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestUpdateActor(t *testing.T) {
+	f := newFakeActorDB()
+	id := f.add("ED", "CHASE")
+	r := newActorRouter(t, f)
+	etag := serve(r, "GET", actorPath(id), "").Header().Get("ETag")
+
+	w := serve(r, "PUT", actorPath(id), `{"first_name": "EDWARD", "last_name": "CHASE"}`, "If-Match", etag)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	actor := decodeActor(t, w)
+	if actor.FirstName != "EDWARD" {
+		t.Errorf("actor = %+v", actor)
+	}
+	newETag := w.Header().Get("ETag")
+	if newETag == etag || newETag != actor.ETag() {
+		t.Errorf("ETag = %s after the update, was %s", newETag, etag)
+	}
+	if f.statements[len(f.statements)-2] != "tx: SELECT actor_id, first_name, last_name, last_update FROM actor WHERE actor_id = $1 FOR UPDATE" {
+		t.Errorf("the update did not lock the row first: %q", f.statements)
+	}
+
+	// The first ETag is stale now
+	w = serve(r, "PUT", actorPath(id), `{"first_name": "E", "last_name": "C"}`, "If-Match", etag)
+	if w.Code != 412 {
+		t.Errorf("stale If-Match status = %d, want 412", w.Code)
+	}
+	if stored, _ := f.get(id); stored.firstName != "EDWARD" {
+		t.Errorf("stale update was written: %+v", stored)
+	}
+
+	// Without If-Match the update is unconditional
+	if w := serve(r, "PUT", actorPath(id), `{"first_name": "E", "last_name": "C"}`); w.Code != 200 {
+		t.Errorf("unconditional update status = %d, want 200", w.Code)
+	}
+	if w := serve(r, "PUT", "/actors/99", `{"first_name": "E", "last_name": "C"}`); w.Code != 404 {
+		t.Errorf("missing actor status = %d, want 404", w.Code)
+	}
+	if w := serve(r, "PUT", actorPath(id), `{"first_name": "E"}`); w.Code != 400 {
+		t.Errorf("partial body status = %d, want 400", w.Code)
+	}
+}
+
+func TestPatchActor(t *testing.T) {
+	f := newFakeActorDB()
+	id := f.add("JENNIFER", "DAVIS")
+	r := newActorRouter(t, f)
+	etag := serve(r, "GET", actorPath(id), "").Header().Get("ETag")
+
+	w := serve(r, "PATCH", actorPath(id), `{"last_name": "DAVIES"}`, "If-Match", etag)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if actor := decodeActor(t, w); actor.FirstName != "JENNIFER" || actor.LastName != "DAVIES" {
+		t.Errorf("actor = %+v, want the first name kept", actor)
+	}
+
+	if w := serve(r, "PATCH", actorPath(id), `{"first_name": "JEN"}`, "If-Match", etag); w.Code != 412 {
+		t.Errorf("stale If-Match status = %d, want 412", w.Code)
+	}
+	if w := serve(r, "PATCH", "/actors/99", `{"first_name": "JEN"}`); w.Code != 404 {
+		t.Errorf("missing actor status = %d, want 404", w.Code)
+	}
+	if w := serve(r, "PATCH", actorPath(id), `{"first_name": ""}`); w.Code != 400 {
+		t.Errorf("empty first_name status = %d, want 400", w.Code)
+	}
+}
+
+func TestDeleteActor(t *testing.T) {
+	f := newFakeActorDB()
+	id := f.add("JOHNNY", "LOLLOBRIGIDA")
+	inUse := f.add("BETTE", "NICHOLSON")
+	f.referenced[inUse] = true
+	r := newActorRouter(t, f)
+
+	if w := serve(r, "DELETE", actorPath(id), "", "If-Match", `"1-0"`); w.Code != 412 {
+		t.Errorf("stale If-Match status = %d, want 412", w.Code)
+	}
+	if _, ok := f.get(id); !ok {
+		t.Fatal("actor deleted despite the stale If-Match")
+	}
+	if f.rollbacks != 1 {
+		t.Errorf("%d rollbacks after the 412, want 1", f.rollbacks)
+	}
+
+	etag := serve(r, "GET", actorPath(id), "").Header().Get("ETag")
+	if w := serve(r, "DELETE", actorPath(id), "", "If-Match", etag); w.Code != 204 {
+		t.Fatalf("status = %d, want 204: %s", w.Code, w.Body)
+	}
+	if w := serve(r, "GET", actorPath(id), ""); w.Code != 404 {
+		t.Errorf("GET after DELETE status = %d, want 404", w.Code)
+	}
+	if w := serve(r, "DELETE", actorPath(id), ""); w.Code != 404 {
+		t.Errorf("second DELETE status = %d, want 404", w.Code)
+	}
+
+	if w := serve(r, "DELETE", actorPath(inUse), ""); w.Code != 409 {
+		t.Errorf("referenced actor status = %d, want 409", w.Code)
+	}
+	if _, ok := f.get(inUse); !ok {
+		t.Error("referenced actor was deleted")
+	}
+}
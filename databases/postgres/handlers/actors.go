@@ -1,8 +1,10 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"postgres-demo/services"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,10 +22,9 @@ func NewActorHandler(svc *services.ActorService) *ActorHandler {
 // ActorCountHandler returns an http.HandlerFunc that closes over the db pool.
 func (h *ActorHandler) ActorCountHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		rowCount, err := h.svc.ActorCount()
+		rowCount, err := h.svc.ActorCount(c.Request.Context())
 		if err != nil {
-			log.Printf("ERROR: could not query database: %v", err)
-			c.String(500, "Internal Server Error")
+			respondActorError(c, err)
 			return
 		}
 		c.String(200, "Number of actors: %d\n", rowCount)
@@ -31,14 +32,145 @@ func (h *ActorHandler) ActorCountHandler() gin.HandlerFunc {
 
 }
 
+// ActorListHandler serves GET /actors, one page at a time
+//
+// Query parameters:
+//   - limit: page size, 1 to 500, default 50
+//   - cursor: next_cursor of the previous page (keyset pagination)
+//   - offset: rows to skip (offset pagination); not with cursor
+//   - first_name, last_name: case-insensitive prefix filters
+//   - sort: actor_id (default), first_name or last_name
+//   - order: asc (default) or desc
+//
+// Responds 200 with the page, its next_cursor and next_offset, or 400 on
+// bad parameters.
 func (h *ActorHandler) ActorListHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		actors, err := h.svc.ActorList()
+		filter, ok := actorFilter(c)
+		if !ok {
+			return
+		}
+		params := services.ActorListParams{
+			ActorFilter: filter,
+			Cursor:      c.Query("cursor"),
+		}
+		for _, q := range []struct {
+			name  string
+			value *int
+		}{
+			{"limit", &params.Limit},
+			{"offset", &params.Offset},
+		} {
+			if v := c.Query(q.name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					c.JSON(400, gin.H{"error": q.name + " must be an integer"})
+					return
+				}
+				*q.value = n
+			}
+		}
+		page, err := h.svc.ActorList(c.Request.Context(), params)
+		if err != nil {
+			respondActorError(c, err)
+			return
+		}
+		c.JSON(200, page)
+	}
+}
+
+// actorFilter reads the first_name, last_name, sort and order query
+// parameters shared by the list and export endpoints, responding 400 when
+// order is invalid
+func actorFilter(c *gin.Context) (services.ActorFilter, bool) {
+	filter := services.ActorFilter{
+		FirstName: c.Query("first_name"),
+		LastName:  c.Query("last_name"),
+		Sort:      c.Query("sort"),
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		c.JSON(400, gin.H{"error": "order must be asc or desc"})
+		return filter, false
+	}
+	return filter, true
+}
+
+// ActorExportHandler serves GET /actors/export and streams every actor
+// matching the list filters (first_name, last_name, sort, order) as a
+// download
+//
+// The format is taken from the format query parameter (csv, ndjson, xlsx)
+// or else from Accept (text/csv, application/x-ndjson, the xlsx media
+// type); CSV is the default. Responds 200 with the file, or 400 on bad
+// parameters.
+func (h *ActorHandler) ActorExportHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, ok := actorFilter(c)
+		if !ok {
+			return
+		}
+		format, err := exportFormat(c)
 		if err != nil {
-			log.Println("Error querying database:", err)
-			c.String(500, "Internal Server Error")
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(200, actors)
+
+		w := &downloadWriter{c: c, format: format}
+		err = h.svc.ExportActors(c.Request.Context(), filter, format, w)
+		switch {
+		case err == nil:
+			// An export without any rows may not have written a byte
+			w.start()
+		case c.Writer.Written():
+			// The status is already sent; cut the download short
+			slog.Warn("actor export cut short", "err", err)
+			c.Abort()
+		default:
+			respondActorError(c, err)
+		}
+	}
+}
+
+// downloadWriter sets the download headers right before the first byte of
+// the export, so that an error response sent instead does not carry them
+type downloadWriter struct {
+	c       *gin.Context
+	format  services.ExportFormat
+	started bool
+}
+
+func (w *downloadWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	w.c.Header("Content-Type", w.format.ContentType())
+	w.c.Header("Content-Disposition", `attachment; filename="actors.`+string(w.format)+`"`)
+	w.c.Status(200)
+}
+
+func (w *downloadWriter) Write(p []byte) (int, error) {
+	w.start()
+	return w.c.Writer.Write(p)
+}
+
+// exportFormat picks the export format from ?format= or Accept
+func exportFormat(c *gin.Context) (services.ExportFormat, error) {
+	if f := c.Query("format"); f != "" {
+		return services.ParseExportFormat(f)
+	}
+	accept := c.GetHeader("Accept")
+	for _, f := range []services.ExportFormat{services.ExportNDJSON, services.ExportXLSX, services.ExportCSV} {
+		if strings.Contains(accept, strings.SplitN(f.ContentType(), ";", 2)[0]) {
+			return f, nil
+		}
+	}
+	if strings.Contains(accept, "application/ndjson") {
+		return services.ExportNDJSON, nil
 	}
+	return services.ExportCSV, nil
 }
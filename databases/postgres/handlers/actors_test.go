@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"postgres-demo/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestActorExportErrorHasNoDownloadHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// The fake driver does not know the export query and fails it
+	db := newFakeActorDB().open()
+	defer db.Close()
+	h := NewActorHandler(services.NewActorService(db, time.Second))
+	r := gin.New()
+	r.GET("/actors/export", h.ActorExportHandler())
+
+	for _, format := range []string{"csv", "ndjson", "xlsx"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/actors/export?format="+format, nil))
+
+		if w.Code != 500 {
+			t.Errorf("%s: status = %d, want 500", format, w.Code)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != "" {
+			t.Errorf("%s: error response has Content-Disposition %q", format, got)
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+			t.Errorf("%s: error response has Content-Type %q", format, got)
+		}
+	}
+}
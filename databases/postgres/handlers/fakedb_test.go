@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// fakeActorDB is an in-memory actor table behind a database/sql driver. It
+// understands exactly the statements of services/actor_crud.go and fails
+// on anything else, so a changed query shows up as a test failure.
+type fakeActorDB struct {
+	mu     sync.Mutex
+	actors map[int64]fakeActor
+	nextID int64
+	now    time.Time
+	// referenced actors fail to delete with a foreign key violation
+	referenced map[int64]bool
+
+	commits, rollbacks int
+	// statements lists every statement run, prefixed with "tx: " inside a
+	// transaction
+	statements []string
+}
+
+type fakeActor struct {
+	firstName, lastName string
+	lastUpdate          time.Time
+}
+
+func newFakeActorDB() *fakeActorDB {
+	return &fakeActorDB{
+		actors:     map[int64]fakeActor{},
+		nextID:     1,
+		now:        time.Date(2025, 7, 1, 9, 0, 0, 0, time.UTC),
+		referenced: map[int64]bool{},
+	}
+}
+
+// add inserts an actor directly and returns its id
+func (f *fakeActorDB) add(firstName, lastName string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := f.nextID
+	f.nextID++
+	f.actors[id] = fakeActor{firstName, lastName, f.tick()}
+	return id
+}
+
+func (f *fakeActorDB) get(id int64) (fakeActor, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.actors[id]
+	return a, ok
+}
+
+// tick advances the clock so that every write gets a new last_update
+func (f *fakeActorDB) tick() time.Time {
+	f.now = f.now.Add(time.Second)
+	return f.now
+}
+
+// open returns a *sql.DB whose connections all use f
+func (f *fakeActorDB) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{f})
+}
+
+type fakeConnector struct{ db *fakeActorDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: c.db}, nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	db   *fakeActorDB
+	inTx bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake driver: Prepare is not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if c.inTx {
+		return nil, errors.New("fake driver: nested transaction")
+	}
+	c.inTx = true
+	return fakeTx{c}, nil
+}
+
+type fakeTx struct{ c *fakeConn }
+
+func (t fakeTx) Commit() error {
+	t.c.db.mu.Lock()
+	defer t.c.db.mu.Unlock()
+	t.c.inTx = false
+	t.c.db.commits++
+	return nil
+}
+
+// Rollback only counts: the tests check that a failed request rolled back
+// before it wrote anything
+func (t fakeTx) Rollback() error {
+	t.c.db.mu.Lock()
+	defer t.c.db.mu.Unlock()
+	t.c.inTx = false
+	t.c.db.rollbacks++
+	return nil
+}
+
+const actorColumns = "actor_id, first_name, last_name, last_update"
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	f := c.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c.record(query)
+
+	switch {
+	case query == "SELECT "+actorColumns+" FROM actor WHERE actor_id = $1",
+		query == "SELECT "+actorColumns+" FROM actor WHERE actor_id = $1 FOR UPDATE":
+		id := args[0].Value.(int64)
+		a, ok := f.actors[id]
+		if !ok {
+			return &fakeRows{}, nil
+		}
+		return rowsOf(id, a), nil
+
+	case query == "INSERT INTO actor (first_name, last_name, last_update) VALUES ($1, $2, now()) RETURNING "+actorColumns:
+		id := f.nextID
+		f.nextID++
+		a := fakeActor{args[0].Value.(string), args[1].Value.(string), f.tick()}
+		f.actors[id] = a
+		return rowsOf(id, a), nil
+
+	case query == "UPDATE actor SET first_name = $2, last_name = $3, last_update = now() WHERE actor_id = $1 RETURNING "+actorColumns:
+		id := args[0].Value.(int64)
+		if _, ok := f.actors[id]; !ok {
+			return &fakeRows{}, nil
+		}
+		a := fakeActor{args[1].Value.(string), args[2].Value.(string), f.tick()}
+		f.actors[id] = a
+		return rowsOf(id, a), nil
+	}
+	return nil, fmt.Errorf("fake driver: unexpected query %q", query)
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	f := c.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c.record(query)
+
+	if query == "DELETE FROM actor WHERE actor_id = $1" {
+		id := args[0].Value.(int64)
+		if f.referenced[id] {
+			return nil, &pq.Error{Code: "23503", Message: "violates foreign key constraint"}
+		}
+		_, ok := f.actors[id]
+		delete(f.actors, id)
+		if !ok {
+			return driver.RowsAffected(0), nil
+		}
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("fake driver: unexpected statement %q", query)
+}
+
+// record must be called with f.mu held
+func (c *fakeConn) record(query string) {
+	if c.inTx {
+		query = "tx: " + query
+	}
+	c.db.statements = append(c.db.statements, query)
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func rowsOf(id int64, a fakeActor) *fakeRows {
+	return &fakeRows{rows: [][]driver.Value{{id, a.firstName, a.lastName, a.lastUpdate}}}
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"actor_id", "first_name", "last_name", "last_update"}
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
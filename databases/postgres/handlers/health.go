@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	db          *sql.DB
+	pingTimeout time.Duration
+}
+
+// NewHealthHandler creates a HealthHandler whose readiness check pings db
+// for at most pingTimeout
+func NewHealthHandler(db *sql.DB, pingTimeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		db:          db,
+		pingTimeout: pingTimeout,
+	}
+}
+
+// HealthzHandler serves GET /healthz: 200 as long as the process serves
+// requests
+func (h *HealthHandler) HealthzHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
+
+// ReadyzHandler serves GET /readyz: 200 when Postgres answers a ping within
+// the timeout, 503 otherwise
+func (h *HealthHandler) ReadyzHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), h.pingTimeout)
+		defer cancel()
+
+		start := time.Now()
+		if err := h.db.PingContext(ctx); err != nil {
+			slog.Warn("readiness check failed", "err", err)
+			c.JSON(503, gin.H{"status": "unavailable", "error": "database ping failed"})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "ping_ms": time.Since(start).Milliseconds()})
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// knownMethods are recorded under their own name; any other method is
+// recorded as OTHER so that clients cannot create series at will
+var knownMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+type routeKey struct {
+	method string
+	route  string
+	status int
+}
+
+type routeStats struct {
+	buckets []uint64 // cumulative counts per latencyBuckets entry
+	count   uint64
+	sum     float64
+}
+
+// Metrics counts requests per route and exposes them with the pool
+// statistics of db in the Prometheus text format
+type Metrics struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	routes map[routeKey]*routeStats
+}
+
+func NewMetrics(db *sql.DB) *Metrics {
+	return &Metrics{
+		db:     db,
+		routes: make(map[routeKey]*routeStats),
+	}
+}
+
+// Middleware records the count and latency of every request under its
+// route pattern, so /actors/1 and /actors/2 share one series
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		elapsed := time.Since(start).Seconds()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		if !slices.Contains(knownMethods, method) {
+			method = "OTHER"
+		}
+		key := routeKey{method: method, route: route, status: c.Writer.Status()}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		stats, ok := m.routes[key]
+		if !ok {
+			stats = &routeStats{buckets: make([]uint64, len(latencyBuckets))}
+			m.routes[key] = stats
+		}
+		for i, bound := range latencyBuckets {
+			if elapsed <= bound {
+				stats.buckets[i]++
+			}
+		}
+		stats.count++
+		stats.sum += elapsed
+	}
+}
+
+// Handler serves GET /metrics
+func (m *Metrics) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(200)
+		m.writeDBStats(c.Writer)
+		m.writeRoutes(c.Writer)
+	}
+}
+
+func (m *Metrics) writeDBStats(w io.Writer) {
+	stats := m.db.Stats()
+	for _, metric := range []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"db_max_open_connections", "gauge", "Maximum number of open connections to the database.", float64(stats.MaxOpenConnections)},
+		{"db_open_connections", "gauge", "The number of established connections both in use and idle.", float64(stats.OpenConnections)},
+		{"db_in_use_connections", "gauge", "The number of connections currently in use.", float64(stats.InUse)},
+		{"db_idle_connections", "gauge", "The number of idle connections.", float64(stats.Idle)},
+		{"db_wait_count_total", "counter", "The total number of connections waited for.", float64(stats.WaitCount)},
+		{"db_wait_duration_seconds_total", "counter", "The total time blocked waiting for a new connection.", stats.WaitDuration.Seconds()},
+		{"db_max_idle_closed_total", "counter", "The total number of connections closed due to SetMaxIdleConns.", float64(stats.MaxIdleClosed)},
+		{"db_max_idle_time_closed_total", "counter", "The total number of connections closed due to SetConnMaxIdleTime.", float64(stats.MaxIdleTimeClosed)},
+		{"db_max_lifetime_closed_total", "counter", "The total number of connections closed due to SetConnMaxLifetime.", float64(stats.MaxLifetimeClosed)},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+			metric.name, metric.help, metric.name, metric.kind, metric.name, formatFloat(metric.value))
+	}
+}
+
+func (m *Metrics) writeRoutes(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]routeKey, 0, len(m.routes))
+	for key := range m.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	fmt.Fprint(w, "# HELP http_requests_total The total number of HTTP requests.\n# TYPE http_requests_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(w, "http_requests_total{%s} %d\n", key.labels(), m.routes[key].count)
+	}
+
+	fmt.Fprint(w, "# HELP http_request_duration_seconds The HTTP request latencies in seconds.\n# TYPE http_request_duration_seconds histogram\n")
+	for _, key := range keys {
+		stats, labels := m.routes[key], key.labels()
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), stats.buckets[i])
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, stats.count)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(stats.sum))
+		fmt.Fprintf(w, "http_request_duration_seconds_count{%s} %d\n", labels, stats.count)
+	}
+}
+
+func (k routeKey) labels() string {
+	return fmt.Sprintf(`method="%s",route="%s",status="%d"`, escapeLabel(k.method), escapeLabel(k.route), k.status)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
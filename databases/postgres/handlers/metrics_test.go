@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Unknown methods and routes are folded into one series each, so that a
+// client cannot grow the metrics without bound
+func TestMetricsFoldLabels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newFakeActorDB().open()
+	defer db.Close()
+	m := NewMetrics(db)
+
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/actors/:id", func(c *gin.Context) { c.Status(200) })
+	r.GET("/metrics", m.Handler())
+
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/actors/1"},
+		{"GET", "/actors/2"},
+		{"BREW", "/actors/1"},
+		{"PROPFIND", "/actors/1"},
+		{"GET", "/nope/1"},
+		{"GET", "/nope/2"},
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/actors/:id",status="200"} 2`,
+		`http_requests_total{method="OTHER",route="unmatched",status="404"} 2`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 2`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics lack %s:\n%s", want, body)
+		}
+	}
+	for _, method := range []string{"BREW", "PROPFIND"} {
+		if strings.Contains(body, method) {
+			t.Errorf("metrics have a %s series", method)
+		}
+	}
+}
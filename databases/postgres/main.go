@@ -1,44 +1,151 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"migrate"
+	"net/http"
 	"os"
+	"os/signal"
 	"postgres-demo/handlers"
+	"postgres-demo/migrations"
 	"postgres-demo/services"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 func main() {
-	err := godotenv.Load()
+	cfg, err := loadConfig(os.Args[1:])
 	if err != nil {
-		log.Printf("cannot find .env file: %v", err)
+		fatal(err.Error())
 	}
 
-	dsn := os.Getenv("POSTGRES_DSN")
-	if dsn == "" {
-		log.Fatal("POSTGRES_DSN is not set")
+	slog.SetLogLoggerLevel(cfg.LogLevel)
+	if cfg.LogLevel > slog.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
 	}
-	db, err := sql.Open("postgres", dsn)
+
+	db, err := sql.Open("postgres", cfg.DSN)
 	if err != nil {
-		log.Fatalf("Error opening database: %v", err)
+		fatal("could not open the database", "err", err)
 	}
-	defer db.Close()
 
-	fmt.Println("Successfully connected to the database!")
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if len(cfg.Args) > 0 && cfg.Args[0] != "migrate" {
+		fatal(fmt.Sprintf("unknown command %q\n%s", cfg.Args[0], migrate.Usage))
+	}
+	migrator, err := migrate.New(db, migrations.FS, ".", "postgres_demo_schema_migrations")
+	if err != nil {
+		fatal("could not load the migrations", "err", err)
+	}
+	done, err := migrate.Run(context.Background(), migrator, cfg.Args, cfg.AutoMigrate, os.Stdout)
+	if done || err != nil {
+		db.Close()
+		if err != nil {
+			fatal("migrate failed", "err", err)
+		}
+		return
+	}
+
+	// sql.Open only validates the DSN; ping so that a bad setup shows up in
+	// the log right away. The service still starts and /readyz reports 503
+	// until the database answers.
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.PingTimeout)
+	if err := db.PingContext(ctx); err != nil {
+		slog.Warn("cannot reach the database", "err", err)
+	} else {
+		slog.Info("connected to the database")
+	}
+	cancel()
 
 	r := gin.Default()
+	metrics := handlers.NewMetrics(db)
+	r.Use(metrics.Middleware())
+
+	healthHandler := handlers.NewHealthHandler(db, cfg.PingTimeout)
+	r.GET("/healthz", healthHandler.HealthzHandler())
+	r.GET("/readyz", healthHandler.ReadyzHandler())
+	r.GET("/metrics", metrics.Handler())
 
-	actorService := services.NewActorService(db)
+	// The query timeout bounds every actor query. lib/pq ignores the
+	// context while it establishes a connection; set connect_timeout in
+	// POSTGRES_DSN to bound that part too.
+	actorService := services.NewActorService(db, cfg.QueryTimeout)
 	actorHandler := handlers.NewActorHandler(actorService)
 
 	r.GET("/actors/count", actorHandler.ActorCountHandler())
 	r.GET("/actors", actorHandler.ActorListHandler())
+	r.GET("/actors/export", actorHandler.ActorExportHandler())
+	r.POST("/actors", actorHandler.CreateActorHandler())
+	r.GET("/actors/:id", actorHandler.GetActorHandler())
+	r.PUT("/actors/:id", actorHandler.UpdateActorHandler())
+	r.PATCH("/actors/:id", actorHandler.PatchActorHandler())
+	r.DELETE("/actors/:id", actorHandler.DeleteActorHandler())
+
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	if err := serve(srv, cfg); err != nil {
+		slog.Error("server failed", "err", err)
+	}
+
+	// Close the pool only after the last request has finished with it
+	if err := db.Close(); err != nil {
+		slog.Error("could not close the database", "err", err)
+	}
+	slog.Info("server stopped")
+}
+
+// serve runs srv until SIGINT or SIGTERM, then stops accepting connections
+// and gives in-flight requests cfg.ShutdownTimeout to finish
+func serve(srv *http.Server, cfg *Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", srv.Addr)
+		errc <- srv.ListenAndServe()
+	}()
 
-	r.Run("0.0.0.0:8080")
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal kills the process right away
+	stop()
+
+	slog.Info("shutting down, draining requests", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Cut off whatever is still running so that the pool can close
+		srv.Close()
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
 
+// fatal logs msg at error level and exits, like log.Fatal but through slog
+// so that the configured handler and level apply
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
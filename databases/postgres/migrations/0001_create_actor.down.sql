@@ -0,0 +1,11 @@
+-- An actor table that existed before the up migration holds sample or
+-- production data and is left alone
+DO $$
+BEGIN
+    IF obj_description(to_regclass('actor'), 'pg_class') = 'created by migration 0001_create_actor' THEN
+        DROP TABLE actor;
+    ELSIF to_regclass('actor') IS NOT NULL THEN
+        RAISE NOTICE 'actor was not created by migration 0001_create_actor; not dropping it';
+    END IF;
+END
+$$;
@@ -0,0 +1,18 @@
+-- The actor table of the dvdrental/sakila sample database. Databases
+-- restored from the sample already have it and keep it as it is; only a
+-- table created here is marked with the comment that lets the down
+-- migration drop it.
+DO $$
+BEGIN
+    IF to_regclass('actor') IS NULL THEN
+        CREATE TABLE actor (
+            actor_id    serial PRIMARY KEY,
+            first_name  varchar(45) NOT NULL,
+            last_name   varchar(45) NOT NULL,
+            last_update timestamp NOT NULL DEFAULT now()
+        );
+        CREATE INDEX idx_actor_last_name ON actor (last_name);
+        COMMENT ON TABLE actor IS 'created by migration 0001_create_actor';
+    END IF;
+END
+$$;
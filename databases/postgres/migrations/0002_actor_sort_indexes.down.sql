@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_actor_first_name_actor_id;
+DROP INDEX IF EXISTS idx_actor_last_name_actor_id;
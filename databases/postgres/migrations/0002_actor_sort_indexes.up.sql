@@ -0,0 +1,3 @@
+-- Keyset pagination of GET /actors sorted by first_name or last_name
+CREATE INDEX IF NOT EXISTS idx_actor_first_name_actor_id ON actor (first_name, actor_id);
+CREATE INDEX IF NOT EXISTS idx_actor_last_name_actor_id ON actor (last_name, actor_id);
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// ActorNotFoundError is returned when no actor has ActorID
+type ActorNotFoundError struct {
+	ActorID int
+}
+
+func (e *ActorNotFoundError) Error() string {
+	return "actor not found: " + strconv.Itoa(e.ActorID)
+}
+
+// ErrPreconditionFailed is returned when If-Match does not match the
+// current ETag of the actor
+var ErrPreconditionFailed = errors.New("actor was modified by another request")
+
+// ErrActorInUse is returned when deleting an actor that films still
+// reference
+var ErrActorInUse = errors.New("actor is referenced by films")
+
+// ETag identifies one version of the actor; last_update changes on every
+// write
+func (a Actor) ETag() string {
+	return fmt.Sprintf(`"%d-%x"`, a.ActorID, a.LastUpdate.UnixNano())
+}
+
+// ActorInput is the body of POST and PUT /actors
+type ActorInput struct {
+	FirstName string `json:"first_name" binding:"required,max=45"`
+	LastName  string `json:"last_name" binding:"required,max=45"`
+}
+
+// ActorPatch is the body of PATCH /actors/:id; nil fields are kept
+type ActorPatch struct {
+	FirstName *string `json:"first_name" binding:"omitempty,min=1,max=45"`
+	LastName  *string `json:"last_name" binding:"omitempty,min=1,max=45"`
+}
+
+// GetActor returns the actor with id
+func (s *ActorService) GetActor(ctx context.Context, id int) (*Actor, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	actor, err := scanActor(s.db.QueryRowContext(ctx,
+		"SELECT actor_id, first_name, last_name, last_update FROM actor WHERE actor_id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &ActorNotFoundError{ActorID: id}
+	}
+	if err != nil {
+		slog.Error("could not query actor", "actor_id", id, "err", err)
+		return nil, contextError(ctx, err)
+	}
+	return actor, nil
+}
+
+// CreateActor inserts a new actor
+func (s *ActorService) CreateActor(ctx context.Context, in ActorInput) (*Actor, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	actor, err := scanActor(s.db.QueryRowContext(ctx,
+		"INSERT INTO actor (first_name, last_name, last_update) VALUES ($1, $2, now()) "+
+			"RETURNING actor_id, first_name, last_name, last_update",
+		in.FirstName, in.LastName))
+	if err != nil {
+		slog.Error("could not insert actor", "err", err)
+		return nil, contextError(ctx, err)
+	}
+	return actor, nil
+}
+
+// UpdateActor replaces the names of actor id. ifMatch is the ETag the
+// client last saw; empty or "*" updates unconditionally.
+func (s *ActorService) UpdateActor(ctx context.Context, id int, in ActorInput, ifMatch string) (*Actor, error) {
+	return s.PatchActor(ctx, id, ActorPatch{FirstName: &in.FirstName, LastName: &in.LastName}, ifMatch)
+}
+
+// PatchActor changes the non-nil fields of patch. ifMatch works as in
+// UpdateActor.
+func (s *ActorService) PatchActor(ctx context.Context, id int, patch ActorPatch, ifMatch string) (*Actor, error) {
+	var updated *Actor
+	err := s.withLockedActor(ctx, id, ifMatch, func(ctx context.Context, tx *sql.Tx, current *Actor) error {
+		if patch.FirstName != nil {
+			current.FirstName = *patch.FirstName
+		}
+		if patch.LastName != nil {
+			current.LastName = *patch.LastName
+		}
+		var err error
+		updated, err = scanActor(tx.QueryRowContext(ctx,
+			"UPDATE actor SET first_name = $2, last_name = $3, last_update = now() WHERE actor_id = $1 "+
+				"RETURNING actor_id, first_name, last_name, last_update",
+			id, current.FirstName, current.LastName))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteActor deletes actor id. ifMatch works as in UpdateActor.
+func (s *ActorService) DeleteActor(ctx context.Context, id int, ifMatch string) error {
+	return s.withLockedActor(ctx, id, ifMatch, func(ctx context.Context, tx *sql.Tx, _ *Actor) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM actor WHERE actor_id = $1", id)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrActorInUse
+		}
+		return err
+	})
+}
+
+// withLockedActor runs fn in a transaction holding the row lock of actor
+// id, after checking ifMatch against its current ETag. The query timeout
+// covers the whole transaction.
+func (s *ActorService) withLockedActor(ctx context.Context, id int, ifMatch string, fn func(context.Context, *sql.Tx, *Actor) error) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("could not begin transaction", "err", err)
+		return contextError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	current, err := scanActor(tx.QueryRowContext(ctx,
+		"SELECT actor_id, first_name, last_name, last_update FROM actor WHERE actor_id = $1 FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &ActorNotFoundError{ActorID: id}
+	}
+	if err != nil {
+		slog.Error("could not lock actor", "actor_id", id, "err", err)
+		return contextError(ctx, err)
+	}
+	if ifMatch != "" && ifMatch != "*" && ifMatch != current.ETag() {
+		return ErrPreconditionFailed
+	}
+
+	if err := fn(ctx, tx, current); err != nil {
+		if !errors.Is(err, ErrActorInUse) {
+			slog.Error("could not write actor", "actor_id", id, "err", err)
+		}
+		return contextError(ctx, err)
+	}
+	return contextError(ctx, tx.Commit())
+}
+
+func scanActor(row *sql.Row) (*Actor, error) {
+	var actor Actor
+	if err := row.Scan(&actor.ActorID, &actor.FirstName, &actor.LastName, &actor.LastUpdate); err != nil {
+		return nil, err
+	}
+	return &actor, nil
+}
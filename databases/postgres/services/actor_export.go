@@ -0,0 +1,233 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// ExportFormat is the encoding of GET /actors/export
+type ExportFormat string
+
+const (
+	ExportCSV    ExportFormat = "csv"
+	ExportNDJSON ExportFormat = "ndjson"
+	ExportXLSX   ExportFormat = "xlsx"
+)
+
+// ParseExportFormat accepts csv, ndjson and xlsx
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch f := ExportFormat(s); f {
+	case ExportCSV, ExportNDJSON, ExportXLSX:
+		return f, nil
+	}
+	return "", &InvalidParamError{"format", "must be csv, ndjson or xlsx"}
+}
+
+// ContentType returns the media type of the format
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// actorEncoder writes actors one at a time
+type actorEncoder interface {
+	Encode(Actor) error
+	Close() error
+}
+
+func newActorEncoder(format ExportFormat, w io.Writer) (actorEncoder, error) {
+	switch format {
+	case ExportCSV:
+		return newCSVActorEncoder(w)
+	case ExportNDJSON:
+		return &ndjsonActorEncoder{enc: json.NewEncoder(w)}, nil
+	case ExportXLSX:
+		return newXLSXActorEncoder(w)
+	}
+	_, err := ParseExportFormat(string(format))
+	return nil, err
+}
+
+// ExportActors streams every actor selected by filter to w. Rows go straight
+// from the result set to w; nothing is written to w when the query fails.
+// The query timeout does not apply, since the export lasts as long as the
+// client reads; canceling ctx stops it.
+func (s *ActorService) ExportActors(ctx context.Context, filter ActorFilter, format ExportFormat, w io.Writer) error {
+	if err := filter.Normalize(); err != nil {
+		return err
+	}
+	query, args, err := buildActorListQuery(ActorListParams{ActorFilter: filter})
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		slog.Error("could not query actors for export", "err", err)
+		return contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	enc, err := newActorEncoder(format, w)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var actor Actor
+		if err := rows.Scan(&actor.ActorID, &actor.FirstName, &actor.LastName, &actor.LastUpdate); err != nil {
+			slog.Error("could not scan actor row", "err", err)
+			return contextError(ctx, err)
+		}
+		if err := enc.Encode(actor); err != nil {
+			slog.Warn("could not write export row", "err", err)
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("could not read actor rows", "err", err)
+		return contextError(ctx, err)
+	}
+	return enc.Close()
+}
+
+var actorExportHeader = []string{"Actor ID", "First Name", "Last Name", "Last Update"}
+
+type csvActorEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVActorEncoder(w io.Writer) (*csvActorEncoder, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(actorExportHeader); err != nil {
+		return nil, err
+	}
+	return &csvActorEncoder{w: cw}, nil
+}
+
+func (e *csvActorEncoder) Encode(a Actor) error {
+	return e.w.Write([]string{
+		strconv.Itoa(a.ActorID),
+		a.FirstName,
+		a.LastName,
+		a.LastUpdate.Format(time.RFC3339),
+	})
+}
+
+func (e *csvActorEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonActorEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonActorEncoder) Encode(a Actor) error {
+	return e.enc.Encode(a)
+}
+
+func (e *ndjsonActorEncoder) Close() error {
+	return nil
+}
+
+// xlsxActorEncoder writes a single-sheet workbook. The sheet is streamed
+// into the zip as rows arrive; the other parts are fixed.
+type xlsxActorEncoder struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+var xlsxStaticParts = []struct{ name, body string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="actors" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func newXLSXActorEncoder(w io.Writer) (*xlsxActorEncoder, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	e := &xlsxActorEncoder{zw: zw, sheet: sheet}
+	if _, err := io.WriteString(sheet, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, err
+	}
+	cells := make([]any, len(actorExportHeader))
+	for i, h := range actorExportHeader {
+		cells[i] = h
+	}
+	return e, e.writeRow(cells...)
+}
+
+func (e *xlsxActorEncoder) Encode(a Actor) error {
+	return e.writeRow(a.ActorID, a.FirstName, a.LastName, a.LastUpdate.Format(time.RFC3339))
+}
+
+// writeRow writes ints as numbers and everything else as inline strings
+func (e *xlsxActorEncoder) writeRow(cells ...any) error {
+	e.row++
+	if _, err := fmt.Fprintf(e.sheet, `<row r="%d">`, e.row); err != nil {
+		return err
+	}
+	for _, cell := range cells {
+		var err error
+		switch v := cell.(type) {
+		case int:
+			_, err = fmt.Fprintf(e.sheet, `<c><v>%d</v></c>`, v)
+		default:
+			if _, err = io.WriteString(e.sheet, `<c t="inlineStr"><is><t xml:space="preserve">`); err == nil {
+				if err = xml.EscapeText(e.sheet, []byte(fmt.Sprint(v))); err == nil {
+					_, err = io.WriteString(e.sheet, `</t></is></c>`)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(e.sheet, `</row>`)
+	return err
+}
+
+func (e *xlsxActorEncoder) Close() error {
+	if _, err := io.WriteString(e.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return e.zw.Close()
+}
@@ -1,89 +1,284 @@
 package services
 
 import (
+	"context"
 	"database/sql"
-	"encoding/csv"
-	"log"
-	"os"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ActorService struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration
 }
 
-func NewActorService(db *sql.DB) *ActorService {
+// NewActorService creates an ActorService. Every query gets at most
+// queryTimeout on top of the caller's context; zero disables the limit.
+func NewActorService(db *sql.DB, queryTimeout time.Duration) *ActorService {
 	return &ActorService{
-		db: db,
+		db:           db,
+		queryTimeout: queryTimeout,
 	}
 }
 
-func (s *ActorService) ActorCount() (int, error) {
+// withTimeout derives the context of one query from the caller's
+func (s *ActorService) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// contextError makes a query error caused by ctx match context.Canceled or
+// context.DeadlineExceeded; the driver reports those as its own errors
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	return err
+}
+
+func (s *ActorService) ActorCount(ctx context.Context) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	rowCount := 0
 	// This query might fail if the DB connection is lost at runtime.
-	err := s.db.QueryRow("SELECT COUNT(*) FROM actor").Scan(&rowCount)
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM actor").Scan(&rowCount)
 	if err != nil {
 		// Log the detailed error for server-side observability.
-		log.Printf("ERROR: could not query database: %v", err)
-		return 0, err
+		slog.Error("could not count actors", "err", err)
+		return 0, contextError(ctx, err)
 	}
 	return rowCount, nil
 }
 
 type Actor struct {
-	ActorID   int    `json:"actor_id"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
+	ActorID    int       `json:"actor_id"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	LastUpdate time.Time `json:"last_update"`
 }
 
-func (s *ActorService) ActorList() ([]Actor, error) {
-	rows, err := s.db.Query("SELECT actor_id, first_name, last_name FROM actor")
-	if err != nil {
-		log.Println("Error querying database:", err)
-		return nil, err
+const (
+	DefaultActorPageSize = 50
+	MaxActorPageSize     = 500
+)
+
+// actorSortColumns whitelists the sort fields; the map value is the only
+// text ever spliced into the SQL
+var actorSortColumns = map[string]string{
+	"actor_id":   "actor_id",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+}
+
+// ActorFilter selects and orders actors for listing and export
+type ActorFilter struct {
+	// FirstName and LastName are case-insensitive prefixes
+	FirstName string
+	LastName  string
+	// Sort is one of actor_id (default), first_name or last_name; actor_id
+	// breaks ties
+	Sort string
+	Desc bool
+}
+
+// Normalize applies the default sort and checks it is whitelisted
+func (f *ActorFilter) Normalize() error {
+	if f.Sort == "" {
+		f.Sort = "actor_id"
 	}
-	defer rows.Close()
+	if _, ok := actorSortColumns[f.Sort]; !ok {
+		return &InvalidParamError{"sort", "must be one of actor_id, first_name, last_name"}
+	}
+	return nil
+}
+
+// ActorListParams selects one page of actors. Cursor and Offset are
+// mutually exclusive; with neither the first page is returned.
+type ActorListParams struct {
+	ActorFilter
+	Limit  int
+	Offset int
+	Cursor string
+}
+
+// ActorPage is one page of actors. NextCursor is empty on the last page.
+type ActorPage struct {
+	Actors     []Actor `json:"actors"`
+	Limit      int     `json:"limit"`
+	Offset     int     `json:"offset,omitempty"`
+	HasMore    bool    `json:"has_more"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	NextOffset *int    `json:"next_offset,omitempty"`
+}
+
+// InvalidParamError reports a bad list parameter
+type InvalidParamError struct {
+	Param  string
+	Reason string
+}
+
+func (e *InvalidParamError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Reason)
+}
 
-	file, err := os.Create("actors.csv")
+// actorCursor is the keyset position after the last actor of a page: its
+// sort value and actor_id. It is sent to clients as base64 JSON.
+type actorCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func encodeActorCursor(c actorCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeActorCursor(s string) (actorCursor, error) {
+	var c actorCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		log.Println("Error creating CSV file:", err)
-		return nil, err
+		return c, err
 	}
-	defer file.Close()
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+// likePrefix turns s into an ILIKE pattern matching values starting with s
+func likePrefix(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
 
-	headers := []string{"Actor ID", "First Name", "Last Name"}
-	if err := writer.Write(headers); err != nil {
-		log.Println("Error writing headers:", err)
+// normalize applies the defaults and checks the parameters
+func (p *ActorListParams) normalize() error {
+	if p.Limit == 0 {
+		p.Limit = DefaultActorPageSize
+	}
+	if p.Limit < 1 || p.Limit > MaxActorPageSize {
+		return &InvalidParamError{"limit", fmt.Sprintf("must be between 1 and %d", MaxActorPageSize)}
+	}
+	if p.Offset < 0 {
+		return &InvalidParamError{"offset", "must not be negative"}
+	}
+	if p.Offset > 0 && p.Cursor != "" {
+		return &InvalidParamError{"cursor", "cannot be combined with offset"}
+	}
+	return p.ActorFilter.Normalize()
+}
+
+// buildActorListQuery returns the query for normalized p and its
+// arguments. It fetches one row more than the limit to tell whether a next
+// page exists; with no limit every matching actor is selected.
+func buildActorListQuery(p ActorListParams) (string, []any, error) {
+	column := actorSortColumns[p.Sort]
+	direction, cmp := "ASC", ">"
+	if p.Desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if p.FirstName != "" {
+		where = append(where, "first_name ILIKE "+arg(likePrefix(p.FirstName)))
+	}
+	if p.LastName != "" {
+		where = append(where, "last_name ILIKE "+arg(likePrefix(p.LastName)))
+	}
+	if p.Cursor != "" {
+		cursor, err := decodeActorCursor(p.Cursor)
+		if err != nil || cursor.Sort != p.Sort || cursor.Desc != p.Desc {
+			return "", nil, &InvalidParamError{"cursor", "malformed or from a different sort"}
+		}
+		if column == "actor_id" {
+			where = append(where, "actor_id "+cmp+" "+arg(cursor.ID))
+		} else {
+			where = append(where, fmt.Sprintf("(%s, actor_id) %s (%s, %s)", column, cmp, arg(cursor.Value), arg(cursor.ID)))
+		}
+	}
+
+	query := "SELECT actor_id, first_name, last_name, last_update FROM actor"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + column + " " + direction
+	if column != "actor_id" {
+		query += ", actor_id " + direction
+	}
+	if p.Limit > 0 {
+		query += " LIMIT " + arg(p.Limit+1)
+	}
+	if p.Offset > 0 {
+		query += " OFFSET " + arg(p.Offset)
+	}
+	return query, args, nil
+}
+
+// ActorList returns one page of actors selected by p
+func (s *ActorService) ActorList(ctx context.Context, p ActorListParams) (*ActorPage, error) {
+	if err := p.normalize(); err != nil {
+		return nil, err
+	}
+	query, args, err := buildActorListQuery(p)
+	if err != nil {
 		return nil, err
 	}
 
-	actors := []Actor{}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		slog.Error("could not list actors", "err", err)
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	page := &ActorPage{Actors: []Actor{}, Limit: p.Limit, Offset: p.Offset}
 	for rows.Next() {
 		var actor Actor
-		if err := rows.Scan(&actor.ActorID, &actor.FirstName, &actor.LastName); err != nil {
-			log.Println("Error scanning row:", err)
-			return nil, err
+		if err := rows.Scan(&actor.ActorID, &actor.FirstName, &actor.LastName, &actor.LastUpdate); err != nil {
+			slog.Error("could not scan actor row", "err", err)
+			return nil, contextError(ctx, err)
 		}
-
-		if err := writer.Write([]string{
-			strconv.Itoa(actor.ActorID),
-			actor.FirstName,
-			actor.LastName,
-		}); err != nil {
-			log.Println("Error writing row to CSV:", err)
-			return nil, err
+		if len(page.Actors) == p.Limit {
+			page.HasMore = true
+			break
 		}
-
-		actors = append(actors, actor)
+		page.Actors = append(page.Actors, actor)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Println("Error during rows iteration:", err)
-		return nil, err
+		slog.Error("could not read actor rows", "err", err)
+		return nil, contextError(ctx, err)
 	}
 
-	return actors, nil
+	if page.HasMore {
+		last := page.Actors[len(page.Actors)-1]
+		cursor := actorCursor{Sort: p.Sort, Desc: p.Desc, ID: last.ActorID}
+		switch p.Sort {
+		case "first_name":
+			cursor.Value = last.FirstName
+		case "last_name":
+			cursor.Value = last.LastName
+		}
+		page.NextCursor = encodeActorCursor(cursor)
+		if p.Cursor == "" {
+			next := p.Offset + p.Limit
+			page.NextOffset = &next
+		}
+	}
+	return page, nil
 }
@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"dbtx"
+	"dbtx/migrations"
 	"fmt"
 	"log"
+	"migrate"
 	"net/http"
 	"os"
 
@@ -14,12 +16,9 @@ import (
 )
 
 // --- 1. The Production Interface ---
-// We renamed QueryRow -> QueryRowContext
-// We added ExecContext so this interface can handle writes too if needed.
-type Querier interface {
-	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
-	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
-}
+// Querier (QueryRowContext, ExecContext and QueryContext) lives in package
+// dbtx next to WithTx, so the CLI and this API share it.
+type Querier = dbtx.Querier
 
 // --- 2. The Shared Logic (Context Aware) ---
 
@@ -55,74 +54,48 @@ func main() {
 	}
 	defer db.Close()
 
+	// "migrate up|down|status" manages the schema instead of serving;
+	// AUTO_MIGRATE=true applies pending migrations first
+	migrator, err := migrate.New(db, migrations.FS, ".", "dbtx_schema_migrations")
+	if err != nil {
+		log.Fatal(err)
+	}
+	done, err := migrate.Run(context.Background(), migrator, os.Args[1:], migrate.AutoFromEnv(), os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if done {
+		return
+	}
+
 	// Using Go 1.22+ routing patterns
 	http.HandleFunc("GET /check-user", handleCheckUser(db))
-	http.HandleFunc("POST /create-user", handleCreateUser(db))
+	http.HandleFunc("POST /users", handleCreateUser(db))
+	http.HandleFunc("GET /users", handleListUsers(db))
+	http.HandleFunc("GET /users/{id}", handleGetUser(db))
+	http.HandleFunc("PATCH /users/{id}", handleUpdatePhone(db))
+	http.HandleFunc("DELETE /users/{id}", handleDeleteUser(db))
 
 	fmt.Println("Server running on :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
+// handleCheckUser serves GET /check-user?username= with {"exists": bool}
 func handleCheckUser(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		username := r.URL.Query().Get("username")
-
-		// PASSING CONTEXT: r.Context() holds the request lifecycle
-		exists, err := checkUserExists(r.Context(), db, username)
-
-		if err != nil {
-			// If the user cancelled the request, err will be "context canceled"
-			log.Printf("Check error: %v", err)
-			http.Error(w, "Internal Error", 500)
+		if !validUsername(w, username) {
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]bool{"exists": exists})
-	}
-}
-
-func handleCreateUser(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		username := r.URL.Query().Get("username")
-		phone := "555-0000"
-		ctx := r.Context() // Capture context once
-
-		// 1. Start Transaction
-		// Note: We use BeginTx now, not Begin!
-		tx, err := db.BeginTx(ctx, nil)
-		if err != nil {
-			http.Error(w, "Tx Error", 500)
-			return
-		}
-		defer tx.Rollback()
-
-		// 2. Check (Passes Context + Transaction)
-		exists, err := checkUserExists(ctx, tx, username)
-		if err != nil {
-			http.Error(w, "Check Error", 500)
-			return
-		}
-		if exists {
-			http.Error(w, "User exists", 409)
-			return
-		}
-
-		// 3. Insert (Passes Context)
-		// We use ExecContext
-		_, err = tx.ExecContext(ctx, "INSERT INTO users (username, phone) VALUES ($1, $2)", username, phone)
+		// PASSING CONTEXT: r.Context() holds the request lifecycle
+		exists, err := checkUserExists(r.Context(), db, username)
 		if err != nil {
-			http.Error(w, "Insert Error", 500)
-			return
-		}
-
-		// 4. Commit
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "Commit Error", 500)
+			// A client that went away shows up as context.Canceled
+			writeUserError(w, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
-		fmt.Fprintln(w, "User Created")
+		writeJSON(w, http.StatusOK, map[string]bool{"exists": exists})
 	}
 }
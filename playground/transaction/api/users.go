@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"dbtx"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// maxBodyBytes bounds every JSON request body
+	maxBodyBytes = 1 << 20
+)
+
+var (
+	// usernamePattern allows letters, digits, '.', '_' and '-'; 50 is the
+	// width of users.username
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,50}$`)
+	// phonePattern is E.164: '+', a country code not starting with 0 and at
+	// most 15 digits in total
+	phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+)
+
+type createUserRequest struct {
+	Username string `json:"username"`
+	Phone    string `json:"phone"`
+}
+
+type updatePhoneRequest struct {
+	Phone string `json:"phone"`
+}
+
+type userPage struct {
+	Users   []dbtx.User `json:"users"`
+	Limit   int         `json:"limit"`
+	Offset  int         `json:"offset"`
+	HasMore bool        `json:"has_more"`
+}
+
+// apiError is the body of every error response:
+// {"error": {"code": "...", "message": "..."}}
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// handleCreateUser serves POST /users with a {"username", "phone"} body
+func handleCreateUser(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createUserRequest
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		if !validUsername(w, req.Username) || !validPhone(w, req.Phone) {
+			return
+		}
+
+		var user dbtx.User
+		err := dbtx.WithTx(r.Context(), db, nil, func(ctx context.Context, q dbtx.Querier) error {
+			var err error
+			user, err = dbtx.CreateUser(ctx, q, req.Username, req.Phone)
+			return err
+		})
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/users/%d", user.ID))
+		writeJSON(w, http.StatusCreated, user)
+	}
+}
+
+// handleGetUser serves GET /users/{id}
+func handleGetUser(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userID(w, r)
+		if !ok {
+			return
+		}
+		user, err := dbtx.GetUser(r.Context(), db, id)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, user)
+	}
+}
+
+// handleListUsers serves GET /users?limit=&offset=, ordered by id
+func handleListUsers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := queryInt(w, r, "limit", defaultPageSize, 1, maxPageSize)
+		if !ok {
+			return
+		}
+		offset, ok := queryInt(w, r, "offset", 0, 0, -1)
+		if !ok {
+			return
+		}
+
+		// One extra row tells whether another page follows
+		users, err := dbtx.ListUsers(r.Context(), db, limit+1, offset)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		page := userPage{Users: users, Limit: limit, Offset: offset}
+		if len(users) > limit {
+			page.Users, page.HasMore = users[:limit], true
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// handleUpdatePhone serves PATCH /users/{id} with a {"phone"} body; the
+// phone is the only field that can change
+func handleUpdatePhone(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userID(w, r)
+		if !ok {
+			return
+		}
+		var req updatePhoneRequest
+		if !decodeBody(w, r, &req) || !validPhone(w, req.Phone) {
+			return
+		}
+
+		user, err := dbtx.UpdateUserPhone(r.Context(), db, id, req.Phone)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, user)
+	}
+}
+
+// handleDeleteUser serves DELETE /users/{id}
+func handleDeleteUser(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userID(w, r)
+		if !ok {
+			return
+		}
+		if err := dbtx.DeleteUser(r.Context(), db, id); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// --- Request parsing and validation ---
+// Each helper writes the 400 response itself and reports whether the
+// handler may go on.
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "request body: "+err.Error())
+		return false
+	}
+	if dec.More() {
+		writeError(w, http.StatusBadRequest, "invalid_json", "request body must hold a single JSON object")
+		return false
+	}
+	return true
+}
+
+func validUsername(w http.ResponseWriter, username string) bool {
+	if !usernamePattern.MatchString(username) {
+		writeError(w, http.StatusBadRequest, "invalid_username",
+			"username must be 3 to 50 letters, digits, '.', '_' or '-'")
+		return false
+	}
+	return true
+}
+
+func validPhone(w http.ResponseWriter, phone string) bool {
+	if !phonePattern.MatchString(phone) {
+		writeError(w, http.StatusBadRequest, "invalid_phone",
+			"phone must be in E.164 format, for example +886912345678")
+		return false
+	}
+	return true
+}
+
+func userID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		writeError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// queryInt reads the integer query parameter name, def when it is absent.
+// A negative upper bound means unbounded.
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def, lower, upper int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < lower || (upper >= 0 && n > upper) {
+		msg := fmt.Sprintf("%s must be an integer of at least %d", name, lower)
+		if upper >= 0 {
+			msg = fmt.Sprintf("%s must be an integer between %d and %d", name, lower, upper)
+		}
+		writeError(w, http.StatusBadRequest, "invalid_"+name, msg)
+		return 0, false
+	}
+	return n, true
+}
+
+// --- Responses ---
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Encode error: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]apiError{"error": {Code: code, Message: message}})
+}
+
+// writeUserError maps the errors of the dbtx user functions to responses.
+// Database errors are logged and not shown to the client.
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, dbtx.ErrUserExists):
+		writeError(w, http.StatusConflict, "user_exists", err.Error())
+	case errors.Is(err, dbtx.ErrUserNotFound):
+		writeError(w, http.StatusNotFound, "user_not_found", err.Error())
+	case errors.Is(err, context.Canceled):
+		// The client went away; 499 only shows up in the logs
+		writeError(w, 499, "canceled", "request canceled")
+	default:
+		log.Printf("Database error: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+	}
+}
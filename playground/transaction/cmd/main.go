@@ -2,9 +2,14 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
+	"dbtx"
+	"dbtx/migrations"
+	"errors"
 	"fmt"
 	"log"
+	"migrate"
 	"os"
 	"strings"
 
@@ -44,6 +49,20 @@ func main() {
 	}
 	fmt.Println("Successfully connected to database!")
 
+	// "migrate up|down|status" manages the schema instead of starting the CLI loop;
+	// AUTO_MIGRATE=true applies pending migrations first
+	migrator, err := migrate.New(db, migrations.FS, ".", "dbtx_schema_migrations")
+	if err != nil {
+		log.Fatal(err)
+	}
+	done, err := migrate.Run(context.Background(), migrator, os.Args[1:], migrate.AutoFromEnv(), os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if done {
+		return
+	}
+
 	// 2. Start CLI Loop
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -72,60 +91,17 @@ func main() {
 
 // --- Core Logic ---
 
-// createUser manages the transaction lifecycle
+// createUser inserts the user in a transaction. WithTx handles
+// Begin/Rollback/Commit, so this only describes the work.
 func createUser(db *sql.DB, username, phone string) error {
-	// 1. Start Transaction
-	tx, err := db.Begin()
-	if err != nil {
-		return fmt.Errorf("could not begin transaction: %v", err)
-	}
-	// Safety net: Rollback ensures we don't leave hanging transactions on error.
-	// If Commit() is called successfully later, this Rollback does nothing.
-	defer tx.Rollback()
-
-	// 2. Complex Check (Delegated to helper function)
-	// We pass 'tx' so this check runs inside the current transaction scope
-	exists, err := checkUserExists(tx, username)
-	if err != nil {
-		return fmt.Errorf("failed during existence check: %v", err)
-	}
-
-	if exists {
-		return fmt.Errorf("username '%s' is already taken", username)
-	}
-
-	// 3. Insert New User
-	insertQuery := "INSERT INTO users (username, phone) VALUES ($1, $2)"
-	_, err = tx.Exec(insertQuery, username, phone)
-	if err != nil {
-		return fmt.Errorf("failed to insert user: %v", err)
-	}
-
-	// 4. Commit
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("could not commit transaction: %v", err)
-	}
-
-	return nil
-}
-
-// checkUserExists encapsulates the "Complex Task"
-// Note: It accepts *sql.Tx, not *sql.DB
-func checkUserExists(tx *sql.Tx, username string) (bool, error) {
-	// Imagine more complex logic here (e.g., checking archiving tables, external APIs, etc.)
-	query := "SELECT username FROM users WHERE username = $1"
-
-	var u string
-	err := tx.QueryRow(query, username).Scan(&u)
-
-	if err == sql.ErrNoRows {
-		// No rows found = User does not exist
-		return false, nil
-	} else if err != nil {
-		// Actual database error
-		return false, err
-	}
-
-	// No error = Row found = User exists
-	return true, nil
+	return dbtx.WithTx(context.Background(), db, nil, func(ctx context.Context, q dbtx.Querier) error {
+		_, err := dbtx.CreateUser(ctx, q, username, phone)
+		if errors.Is(err, dbtx.ErrUserExists) {
+			return fmt.Errorf("username '%s': %w", username, err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to insert user: %w", err)
+		}
+		return nil
+	})
 }
@@ -2,4 +2,9 @@ module dbtx
 
 go 1.25.1
 
-require github.com/lib/pq v1.10.9 // indirect
+require (
+	github.com/lib/pq v1.10.9
+	migrate v0.0.0
+)
+
+replace migrate => ../../databases/migrate
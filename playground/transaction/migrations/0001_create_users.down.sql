@@ -0,0 +1,10 @@
+-- A users table that existed before the up migration is left alone
+DO $$
+BEGIN
+    IF obj_description(to_regclass('users'), 'pg_class') = 'created by migration 0001_create_users' THEN
+        DROP TABLE users;
+    ELSIF to_regclass('users') IS NOT NULL THEN
+        RAISE NOTICE 'users was not created by migration 0001_create_users; not dropping it';
+    END IF;
+END
+$$;
@@ -0,0 +1,15 @@
+-- An existing users table is kept as it is; only a table created here is
+-- marked with the comment that lets the down migration drop it
+DO $$
+BEGIN
+    IF to_regclass('users') IS NULL THEN
+        CREATE TABLE users (
+            id         bigserial PRIMARY KEY,
+            username   varchar(50) NOT NULL,
+            phone      varchar(20) NOT NULL,
+            created_at timestamptz NOT NULL DEFAULT now()
+        );
+        COMMENT ON TABLE users IS 'created by migration 0001_create_users';
+    END IF;
+END
+$$;
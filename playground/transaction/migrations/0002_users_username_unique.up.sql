@@ -0,0 +1,2 @@
+-- Remove duplicate usernames first if this fails on an existing table
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON users (username);
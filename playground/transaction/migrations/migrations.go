@@ -0,0 +1,9 @@
+// Package migrations embeds the users schema shared by the CLI and the API
+package migrations
+
+import "embed"
+
+// FS holds the <version>_<name>.up.sql/.down.sql files
+//
+//go:embed *.sql
+var FS embed.FS
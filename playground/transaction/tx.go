@@ -0,0 +1,108 @@
+// Package dbtx holds the transaction plumbing shared by the CLI and the API
+package dbtx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Querier is what *sql.DB and *sql.Tx have in common, so the same logic runs
+// inside or outside a transaction
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// TxOptions configures WithTx. The zero value runs at the database's default
+// isolation level (READ COMMITTED in Postgres) with the default retries.
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxAttempts is how many times a transaction that failed with a
+	// serialization failure runs in total, 3 by default
+	MaxAttempts int
+	// Backoff is the wait before the first retry, 10ms by default. It
+	// doubles with every further retry, with jitter, up to one second.
+	Backoff time.Duration
+}
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = 10 * time.Millisecond
+	maxBackoff         = time.Second
+)
+
+// WithTx runs fn in a transaction. It commits when fn returns nil and rolls
+// back when fn returns an error or panics; the panic is re-raised after the
+// rollback.
+//
+// A serialization failure (SQLSTATE 40001) from fn or from the commit runs
+// fn again in a new transaction, so fn must not have side effects outside
+// the database. opts may be nil.
+func WithTx(ctx context.Context, db *sql.DB, opts *TxOptions, fn func(ctx context.Context, q Querier) error) error {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	attempts := opts.MaxAttempts
+	if attempts < 1 {
+		attempts = defaultMaxAttempts
+	}
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, db, opts, fn)
+		if err == nil || !IsSerializationFailure(err) || attempt == attempts {
+			return err
+		}
+
+		// Full jitter keeps retrying transactions from colliding again
+		wait := time.Duration(rand.Int64N(int64(backoff)) + 1)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (retry canceled: %v)", err, ctx.Err())
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func runTx(ctx context.Context, db *sql.DB, opts *TxOptions, fn func(ctx context.Context, q Querier) error) (err error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// IsSerializationFailure reports whether err is a Postgres serialization
+// failure, which succeeds when the whole transaction is retried
+func IsSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
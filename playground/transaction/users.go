@@ -0,0 +1,111 @@
+package dbtx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUserExists is returned when the username is already taken
+	ErrUserExists = errors.New("username is already taken")
+	// ErrUserNotFound is returned when no user has the given id
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// User is a row of the users table
+type User struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Phone     string    `json:"phone"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const userColumns = "id, username, phone, created_at"
+
+// CreateUser inserts a user and returns it.
+//
+// There is no existence check first: under READ COMMITTED two transactions
+// can both see a username as free and both insert it. The unique index on
+// users.username decides instead; exactly one insert wins and every other
+// one gets ErrUserExists.
+func CreateUser(ctx context.Context, q Querier, username, phone string) (User, error) {
+	user, err := scanUser(q.QueryRowContext(ctx,
+		"INSERT INTO users (username, phone) VALUES ($1, $2) RETURNING "+userColumns, username, phone))
+	if isUniqueViolation(err, "users_username_key") {
+		return User{}, ErrUserExists
+	}
+	return user, err
+}
+
+// GetUser returns the user with the given id or ErrUserNotFound
+func GetUser(ctx context.Context, q Querier, id int64) (User, error) {
+	return scanUser(q.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id))
+}
+
+// ListUsers returns up to limit users ordered by id, skipping the first
+// offset
+func ListUsers(ctx context.Context, q Querier, limit, offset int) ([]User, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT "+userColumns+" FROM users ORDER BY id LIMIT $1 OFFSET $2", limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("listing users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Phone, &user.CreatedAt); err != nil {
+			return nil, fmt.Errorf("listing users: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing users: %w", err)
+	}
+	return users, nil
+}
+
+// UpdateUserPhone sets the phone of the user with the given id and returns
+// the updated user or ErrUserNotFound
+func UpdateUserPhone(ctx context.Context, q Querier, id int64, phone string) (User, error) {
+	return scanUser(q.QueryRowContext(ctx,
+		"UPDATE users SET phone = $2 WHERE id = $1 RETURNING "+userColumns, id, phone))
+}
+
+// DeleteUser deletes the user with the given id or returns ErrUserNotFound
+func DeleteUser(ctx context.Context, q Querier, id int64) error {
+	result, err := q.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("deleting user %d: %w", id, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleting user %d: %w", id, err)
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func scanUser(row *sql.Row) (User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Username, &user.Phone, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	return user, err
+}
+
+// isUniqueViolation reports whether err is a unique violation (SQLSTATE
+// 23505) of the named constraint or index
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
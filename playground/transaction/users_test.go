@@ -0,0 +1,89 @@
+package dbtx
+
+import (
+	"context"
+	"database/sql"
+	"dbtx/migrations"
+	"errors"
+	"fmt"
+	"migrate"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// openTestDB connects to DBTX_TEST_DSN and migrates it, skipping the test
+// when the variable is not set. The database should be a throwaway one.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("DBTX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("DBTX_TEST_DSN not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrations.FS, ".", "dbtx_schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCreateUserConcurrentSameUsername(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	const workers = 20
+	db.SetMaxOpenConns(workers)
+	username := fmt.Sprintf("race-%d", time.Now().UnixNano())
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE username = $1", username) })
+
+	start := make(chan struct{})
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = WithTx(ctx, db, nil, func(ctx context.Context, q Querier) error {
+				_, err := CreateUser(ctx, q, username, "+886912345678")
+				return err
+			})
+		}()
+	}
+	// Release every create at once so that they overlap
+	close(start)
+	wg.Wait()
+
+	created := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrUserExists):
+			t.Errorf("create %d: %v, want nil or ErrUserExists", i, err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d creates succeeded, want exactly 1", created)
+	}
+
+	var rows int
+	if err := db.QueryRow("SELECT count(*) FROM users WHERE username = $1", username).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("%d rows with username %s, want 1", rows, username)
+	}
+}
@@ -2,4 +2,9 @@ module tz-example
 
 go 1.24.3
 
-require github.com/lib/pq v1.10.9 // indirect
+require (
+	github.com/lib/pq v1.10.9 // indirect
+	migrate v0.0.0
+)
+
+replace migrate => ../databases/migrate
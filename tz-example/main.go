@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"migrate"
+	"os"
 	"time"
+	"tz-example/migrations"
 
 	_ "github.com/lib/pq"
 )
@@ -23,6 +27,20 @@ func main() {
 		log.Fatal("Failed to ping database:", err)
 	}
 
+	// "migrate up|down|status" manages the tt table instead of inserting;
+	// AUTO_MIGRATE=true creates it first
+	migrator, err := migrate.New(db, migrations.FS, ".", "tz_example_schema_migrations")
+	if err != nil {
+		log.Fatal(err)
+	}
+	done, err := migrate.Run(context.Background(), migrator, os.Args[1:], migrate.AutoFromEnv(), os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if done {
+		return
+	}
+
 	// 3. Insert now() into table tt
 	var insertedTimeString string
 	query := `INSERT INTO tt (ct) VALUES (now()) RETURNING ct`
@@ -0,0 +1,10 @@
+-- A tt table that existed before the up migration is left alone
+DO $$
+BEGIN
+    IF obj_description(to_regclass('tt'), 'pg_class') = 'created by migration 0001_create_tt' THEN
+        DROP TABLE tt;
+    ELSIF to_regclass('tt') IS NOT NULL THEN
+        RAISE NOTICE 'tt was not created by migration 0001_create_tt; not dropping it';
+    END IF;
+END
+$$;
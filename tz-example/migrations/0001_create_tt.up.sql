@@ -0,0 +1,15 @@
+-- timestamptz stores an instant; the session time zone only affects how
+-- it is printed. An existing tt table is kept as it is; only a table
+-- created here is marked with the comment that lets the down migration
+-- drop it.
+DO $$
+BEGIN
+    IF to_regclass('tt') IS NULL THEN
+        CREATE TABLE tt (
+            id serial PRIMARY KEY,
+            ct timestamptz NOT NULL
+        );
+        COMMENT ON TABLE tt IS 'created by migration 0001_create_tt';
+    END IF;
+END
+$$;
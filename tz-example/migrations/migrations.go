@@ -0,0 +1,9 @@
+// Package migrations embeds the tz-example schema migrations
+package migrations
+
+import "embed"
+
+// FS holds the <version>_<name>.up.sql/.down.sql files
+//
+//go:embed *.sql
+var FS embed.FS